@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"github.com/daedaluz/goconbee/emulator"
+	"github.com/daedaluz/goconbee/serial"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	params := flag.String("params", "", "json file to keep the parameters in, empty keeps them in memory")
+	link := flag.String("link", "", "symlink to create to the pseudo terminal, e.g. /tmp/ttyCONBEE")
+	flag.Parse()
+
+	store := emulator.NewParameterStore()
+	if *params != "" {
+		var err error
+		if store, err = emulator.OpenParameterStore(*params); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	pty, err := emulator.OpenPTY()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pty.Close()
+	path := pty.Name()
+	if *link != "" {
+		os.Remove(*link)
+		if err := os.Symlink(pty.Name(), *link); err != nil {
+			log.Fatal(err)
+		}
+		defer os.Remove(*link)
+		path = *link
+	}
+
+	e := emulator.New(store)
+	e.SendHandler = func(req *serial.SendDataRequest) uint8 {
+		log.Printf("Send: %s profile:0x%.4x cluster:0x%.4x %X", req.DstAddress, req.ProfileID, req.ClusterID, req.Data)
+		return 0
+	}
+	go func() {
+		if err := e.Serve(pty); err != nil {
+			log.Println("Serve:", err)
+		}
+	}()
+	log.Println("Emulating a ConBee II on", path)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+}
@@ -0,0 +1,113 @@
+// Command conbeectl talks to a ConBee from the shell.
+//
+//	conbeectl [-port path] [-platform name] [-baud rate] [-timeout d] raw <command> [payload]
+//
+// raw sends command, e.g. 0x0d, with the payload given as hex and prints the response.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/daedaluz/goconbee/serial"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"os"
+	"strconv"
+	"time"
+)
+
+// platformBaudRates are the baud rates of the adapters, for -platform.
+var platformBaudRates = map[string]int{
+	"conbee":   115200,
+	"conbee2":  115200,
+	"raspbee":  38400,
+	"raspbee2": 115200,
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] raw <command> [payload as hex]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	path := flag.String("port", "", "tty or tcp://host:port of the stick, empty uses the first one found")
+	platform := flag.String("platform", "", "adapter on the tty, one of conbee, conbee2, raspbee and raspbee2, sets the baud rate")
+	baud := flag.Int("baud", 0, "baud rate of the tty, overrides -platform, 115200 if neither is given")
+	timeout := flag.Duration("timeout", time.Second*7, "how long to wait for the response")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		return 2
+	}
+	options := serial.NewOptions()
+	if *platform != "" {
+		rate, ok := platformBaudRates[*platform]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown platform %q\n", *platform)
+			return 2
+		}
+		options.SetBaudRate(rate)
+	}
+	if *baud > 0 {
+		options.SetBaudRate(*baud)
+	}
+
+	switch flag.Arg(0) {
+	case "raw":
+		if flag.NArg() < 2 || flag.NArg() > 3 {
+			usage()
+			return 2
+		}
+		cmd, err := strconv.ParseUint(flag.Arg(1), 0, 8)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "command %q: %s\n", flag.Arg(1), err)
+			return 2
+		}
+		payload, err := hex.DecodeString(flag.Arg(2))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "payload %q: %s\n", flag.Arg(2), err)
+			return 2
+		}
+		port, err := open(*path, options)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer port.Close()
+		ctx := serial.WithCommandTimeout(context.Background(), *timeout)
+		res, err := port.SendRaw(ctx, frame.Command(cmd), payload)
+		if res != nil {
+			fmt.Printf("%s seq:%d status:%s payload:%X\n", res.CommandID(), res.SeqNumber(), res.Status(), res.Data())
+			fmt.Printf("%X\n", []byte(res))
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	default:
+		usage()
+		return 2
+	}
+}
+
+func open(path string, options *serial.Options) (*serial.Port, error) {
+	if path == "" {
+		adapters, err := serial.Discover()
+		if err != nil {
+			return nil, err
+		}
+		if len(adapters) == 0 {
+			return nil, errors.New("no stick found, use -port")
+		}
+		path = adapters[0].Path
+	}
+	return serial.OpenWithOptions(path, nil, options)
+}
@@ -26,20 +26,25 @@ func main() {
 		}
 	}
 
-	port, err = serial.Open("/dev/ttyACM0", &serial.Handlers{
+	path := "/dev/ttyACM0"
+	if adapters, err := serial.Discover(); err == nil && len(adapters) > 0 {
+		log.Printf("Found %s (%s) at %s", adapters[0].Model, adapters[0].Serial, adapters[0].Path)
+		path = adapters[0].Path
+	}
+
+	port, err = serial.OpenWithOptions(path, &serial.Handlers{
 		UnsolicitedHandler: handler,
 		DisconnectHandler: func(port2 *serial.Port) {
 			log.Println("Disconnected")
 		},
-	})
+	}, serial.NewOptions().SetHandshake(true).SetWatchdog(time.Minute, time.Second*30))
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Println(port.ReadFirmwareVersion())
+	log.Println(port.Firmware(), port.Capabilities())
 	PANID := uint16(0)
-	ProtocolVersion := uint16(0)
+	ProtocolVersion := port.ProtocolVersion()
 	port.ReadParameter(serial.ParamNWKPANID, &PANID)
-	port.ReadParameter(serial.ParamProtocolVersion, &ProtocolVersion)
 	NetworkKey, err := port.ReadParameterRaw(serial.ParamNetworkKey)
 	Security := serial.SecurityMode(0)
 	port.ReadParameter(serial.ParamSecurityMode, &Security)
@@ -47,40 +52,22 @@ func main() {
 
 	log.Printf("%.4X %.4X\n", PANID, ProtocolVersion)
 
+	pump, err := port.StartDataPump(serial.FlagReadShortSourceAddress, 16)
+	if err != nil {
+		log.Fatal(err)
+	}
 	go func() {
-		for {
-			ticker := time.NewTicker(time.Second * 30)
-			port.WriteParameter(serial.ParamWatchdogTTL, uint8(60))
-			for _ = range ticker.C {
-				port.WriteParameter(serial.ParamWatchdogTTL, uint8(60))
-			}
+		for data := range pump.Data() {
+			log.Println("DATA:", data)
 		}
 	}()
-
 	go func() {
-		for {
-			ticker := time.NewTicker(time.Second * 5)
-			for _ = range ticker.C {
-				state, err := port.GetDeviceState()
-				log.Println("State:", state, err)
-				if state.DataIndication {
-					data, err := port.ReadReceivedData(serial.FlagReadShortSourceAddress)
-					log.Println("DATA:", data, err)
-				}
-				if state.DataConfirm {
-					data, err := port.QuerySendData()
-					log.Println(data, err)
-				}
-			}
+		for confirm := range pump.Confirms() {
+			log.Println("Confirm:", confirm)
 		}
 	}()
 
 	log.Println(port.ReadParameterRaw(serial.ParamNWKPANID))
-	state, err := port.GetDeviceState()
-	log.Println(state, err)
-	if state.DataIndication {
-		log.Println(port.ReadReceivedData(serial.FlagReadShortSourceAddress))
-	}
 	log.Println(port.GetDeviceState())
 	log.Println(port.WriteParameter(serial.ParamOpenNetwork, []byte{60}))
 	log.Println(port.ReadParameterRaw(serial.ParamOpenNetwork))
@@ -0,0 +1,373 @@
+// Package emulator implements the serial protocol of the ConBee firmware, so serial.Port and
+// the applications on top of it can be tested without a stick attached.
+//
+// The emulator keeps a parameter store, the network state and the queues of the APS layer:
+// requests are confirmed through SendHandler and indications are added with Indicate. Every
+// change of the device state is reported to the connected ports with a device state changed frame.
+//
+//	pty, _ := emulator.OpenPTY()
+//	go emulator.New(nil).Serve(pty)
+//	port, _ := serial.Open(pty.Name(), nil)
+package emulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/daedaluz/goconbee/serial"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"github.com/daedaluz/goslip"
+	"io"
+	"log"
+	"sync"
+)
+
+// RequestSlots is the number of APS requests the emulated firmware holds until they are confirmed.
+const RequestSlots = 4
+
+const (
+	stateDataConfirm    = 0x04
+	stateDataIndication = 0x08
+	stateFreeSlots      = 0x20
+)
+
+// SendHandler is called for every APS data request, it returns the status of the confirm.
+type SendHandler func(req *serial.SendDataRequest) uint8
+
+type Emulator struct {
+	// Platform, Major and Minor are reported as the firmware version.
+	Platform serial.Platform
+	Major    uint8
+	Minor    uint8
+	// SendHandler confirms APS data requests, nil confirms every request with success.
+	SendHandler SendHandler
+	Logger      *log.Logger
+
+	params *ParameterStore
+
+	lock         sync.Mutex
+	networkState serial.NetworkState
+	confirms     []confirm
+	indications  []*serial.ApsData
+	sessions     map[*session]struct{}
+	lastState    byte
+	seq          uint8
+}
+
+type confirm struct {
+	req    *serial.SendDataRequest
+	status uint8
+}
+
+type session struct {
+	lock sync.Mutex
+	rw   slip.ReadWriter
+}
+
+func (s *session) write(f frame.Frame) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rw.WritePacket(f)
+}
+
+// New returns a connected ConBee II coordinator, params nil uses NewParameterStore.
+func New(params *ParameterStore) *Emulator {
+	if params == nil {
+		params = NewParameterStore()
+	}
+	e := &Emulator{
+		Platform:     serial.Conbee2,
+		Major:        0x26,
+		Minor:        0x72,
+		Logger:       log.Default(),
+		params:       params,
+		networkState: serial.NetConnected,
+		sessions:     make(map[*session]struct{}),
+	}
+	e.lastState = e.deviceState()
+	return e
+}
+
+func (e *Emulator) Parameters() *ParameterStore {
+	return e.params
+}
+
+// Serve answers the frames read from rw until reading fails.
+func (e *Emulator) Serve(rw io.ReadWriter) error {
+	s := &session{rw: slip.NewReadWriter(rw)}
+	e.lock.Lock()
+	e.sessions[s] = struct{}{}
+	e.lock.Unlock()
+	defer func() {
+		e.lock.Lock()
+		delete(e.sessions, s)
+		e.lock.Unlock()
+	}()
+	for {
+		data, err := s.rw.ReadPacket()
+		if err != nil {
+			return err
+		}
+		f := frame.Frame(data)
+		if len(f) < 7 || !f.CheckCRC() {
+			continue
+		}
+		if res := e.handle(f); res != nil {
+			if err := s.write(res); err != nil {
+				return err
+			}
+		}
+		e.notify()
+	}
+}
+
+func (e *Emulator) NetworkState() serial.NetworkState {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.networkState
+}
+
+// SetNetworkState changes the network state as if the firmware had joined or left the network.
+func (e *Emulator) SetNetworkState(state serial.NetworkState) {
+	e.lock.Lock()
+	e.networkState = state
+	e.lock.Unlock()
+	e.notify()
+}
+
+// Indicate queues data as if it was received from the network, it is read with Port.ReadReceivedData.
+func (e *Emulator) Indicate(ind *serial.ApsData) {
+	e.lock.Lock()
+	e.indications = append(e.indications, ind)
+	e.lock.Unlock()
+	e.notify()
+}
+
+// deviceState returns the device state byte, e.lock must be held.
+func (e *Emulator) deviceState() byte {
+	state := byte(e.networkState)
+	if len(e.confirms) > 0 {
+		state |= stateDataConfirm
+	}
+	if len(e.indications) > 0 {
+		state |= stateDataIndication
+	}
+	if len(e.confirms) < RequestSlots {
+		state |= stateFreeSlots
+	}
+	return state
+}
+
+// notify sends a device state changed frame to every session if the device state has changed.
+func (e *Emulator) notify() {
+	e.lock.Lock()
+	state := e.deviceState()
+	if state == e.lastState {
+		e.lock.Unlock()
+		return
+	}
+	e.lastState = state
+	e.seq++
+	f := frame.NewFrame(frame.CmdDeviceStateChanged, e.seq, []byte{state, 0})
+	sessions := make([]*session, 0, len(e.sessions))
+	for s := range e.sessions {
+		sessions = append(sessions, s)
+	}
+	e.lock.Unlock()
+	for _, s := range sessions {
+		s.write(f)
+	}
+}
+
+func response(req frame.Frame, status frame.Status, payload []byte) frame.Frame {
+	f := frame.NewFrame(req.CommandID(), req.SeqNumber(), payload)
+	f[2] = byte(status)
+	f.UpdateCRC()
+	return f
+}
+
+// withLength prefixes payload with its length, as most commands do.
+func withLength(payload ...byte) []byte {
+	return append(binary.LittleEndian.AppendUint16(nil, uint16(len(payload))), payload...)
+}
+
+func (e *Emulator) handle(f frame.Frame) frame.Frame {
+	switch f.CommandID() {
+	case frame.CmdVersion:
+		return response(f, frame.StatusSuccess, []byte{0, byte(e.Platform), e.Minor, e.Major})
+	case frame.CmdDeviceState:
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		return response(f, frame.StatusSuccess, []byte{e.deviceState(), 0, 0})
+	case frame.CmdChangeNetworkState:
+		return e.changeNetworkState(f)
+	case frame.CmdReadParameter:
+		return e.readParameter(f)
+	case frame.CmdWriteParameter:
+		return e.writeParameter(f)
+	case frame.CmdAPSDataRequest:
+		return e.sendData(f)
+	case frame.CmdAPSDataConfirm:
+		return e.querySendData(f)
+	case frame.CmdAPSDataIndication:
+		return e.readReceivedData(f)
+	}
+	e.Logger.Println("Emulator: unsupported command", f)
+	return response(f, frame.StatusUnsupported, nil)
+}
+
+func (e *Emulator) changeNetworkState(f frame.Frame) frame.Frame {
+	data := f.Data()
+	if len(data) < 1 {
+		return response(f, frame.StatusInvalidValue, []byte{0})
+	}
+	state := serial.NetworkState(data[0])
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	switch state {
+	case serial.NetJoining, serial.NetConnected:
+		e.networkState = serial.NetConnected
+	case serial.NetLeaving, serial.NetOffline:
+		e.networkState = serial.NetOffline
+	default:
+		return response(f, frame.StatusInvalidValue, []byte{data[0]})
+	}
+	return response(f, frame.StatusSuccess, []byte{data[0]})
+}
+
+func (e *Emulator) readParameter(f frame.Frame) frame.Frame {
+	data := f.Data()
+	if len(data) < 3 {
+		return response(f, frame.StatusInvalidValue, withLength(0))
+	}
+	id := serial.ParameterID(data[2])
+	value, err := e.params.Get(id, data[3:])
+	switch err {
+	case nil:
+		return response(f, frame.StatusSuccess, withLength(append([]byte{byte(id)}, value...)...))
+	case ErrUnknownParameter:
+		return response(f, frame.StatusUnsupported, withLength(byte(id)))
+	}
+	return response(f, frame.StatusInvalidValue, withLength(byte(id)))
+}
+
+func (e *Emulator) writeParameter(f frame.Frame) frame.Frame {
+	data := f.Data()
+	if len(data) < 3 {
+		return response(f, frame.StatusInvalidValue, withLength(0))
+	}
+	id := serial.ParameterID(data[2])
+	if parameters[id].readOnly {
+		return response(f, frame.StatusUnsupported, withLength(byte(id)))
+	}
+	switch err := e.params.Set(id, data[3:]); err {
+	case nil:
+		return response(f, frame.StatusSuccess, withLength(byte(id)))
+	case ErrUnknownParameter:
+		return response(f, frame.StatusUnsupported, withLength(byte(id)))
+	case ErrInvalidValue:
+		return response(f, frame.StatusInvalidValue, withLength(byte(id)))
+	default:
+		e.Logger.Println("Emulator: saving parameters failed:", err)
+		return response(f, frame.StatusError, withLength(byte(id)))
+	}
+}
+
+func (e *Emulator) sendData(f frame.Frame) frame.Frame {
+	req, err := serial.DecodeSendDataRequest(f)
+	if err != nil {
+		return response(f, frame.StatusInvalidValue, withLength(0, 0))
+	}
+	e.lock.Lock()
+	online, free := e.networkState == serial.NetConnected, len(e.confirms) < RequestSlots
+	e.lock.Unlock()
+	status := frame.StatusSuccess
+	switch {
+	case !online:
+		status = frame.StatusNoNetwork
+	case !free:
+		status = frame.StatusBusy
+	default:
+		res := uint8(0)
+		if e.SendHandler != nil {
+			res = e.SendHandler(req)
+		}
+		e.lock.Lock()
+		e.confirms = append(e.confirms, confirm{req: req, status: res})
+		e.lock.Unlock()
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return response(f, status, withLength(e.deviceState(), req.RequestID))
+}
+
+func (e *Emulator) querySendData(f frame.Frame) frame.Frame {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.confirms) == 0 {
+		return response(f, frame.StatusFailure, withLength(e.deviceState()))
+	}
+	c := e.confirms[0]
+	e.confirms = e.confirms[1:]
+	buff := &bytes.Buffer{}
+	buff.WriteByte(e.deviceState())
+	buff.WriteByte(c.req.RequestID)
+	writeAddress(buff, c.req.DstAddress)
+	if c.req.DstAddress.Mode != serial.AddressGroup {
+		buff.WriteByte(c.req.DstAddress.Endpoint)
+	}
+	buff.WriteByte(c.req.SrcEP)
+	buff.WriteByte(c.status)
+	buff.Write([]byte{0, 0, 0, 0})
+	return response(f, frame.StatusSuccess, withLength(buff.Bytes()...))
+}
+
+func (e *Emulator) readReceivedData(f frame.Frame) frame.Frame {
+	flags := serial.ReadDataFlag(0)
+	if data := f.Data(); len(data) >= 3 {
+		flags = serial.ReadDataFlag(data[2])
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.indications) == 0 {
+		return response(f, frame.StatusFailure, withLength(e.deviceState()))
+	}
+	ind := e.indications[0]
+	e.indications = e.indications[1:]
+	src := ind.SrcAddress
+	if src.Mode == serial.AddressNWKAndIEEE && flags&serial.FlagIncludeShortAndExtendedAddress == 0 {
+		src.Mode = serial.AddressNWK
+	}
+	lastHop := uint16(0)
+	if flags&serial.FlagLastHop > 0 {
+		lastHop = ind.LastHop
+	}
+	buff := &bytes.Buffer{}
+	buff.WriteByte(e.deviceState())
+	writeAddress(buff, ind.DstAddress)
+	buff.WriteByte(ind.DstAddress.Endpoint)
+	writeAddress(buff, src)
+	buff.WriteByte(src.Endpoint)
+	binary.Write(buff, binary.LittleEndian, ind.ProfileID)
+	binary.Write(buff, binary.LittleEndian, ind.ClusterID)
+	binary.Write(buff, binary.LittleEndian, uint16(len(ind.Data)))
+	buff.Write(ind.Data)
+	binary.Write(buff, binary.LittleEndian, lastHop)
+	buff.WriteByte(ind.LQI)
+	buff.Write([]byte{0, 0, 0, 0})
+	buff.WriteByte(byte(ind.RSSI))
+	return response(f, frame.StatusSuccess, withLength(buff.Bytes()...))
+}
+
+// writeAddress writes the mode and address of a, without the endpoint.
+func writeAddress(buff *bytes.Buffer, a serial.Address) {
+	buff.WriteByte(byte(a.Mode))
+	switch a.Mode {
+	case serial.AddressGroup, serial.AddressNWK:
+		binary.Write(buff, binary.LittleEndian, a.Short)
+	case serial.AddressIEEE:
+		binary.Write(buff, binary.LittleEndian, a.Extended)
+	case serial.AddressNWKAndIEEE:
+		binary.Write(buff, binary.LittleEndian, a.Short)
+		binary.Write(buff, binary.LittleEndian, a.Extended)
+	}
+}
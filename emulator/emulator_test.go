@@ -0,0 +1,237 @@
+package emulator
+
+import (
+	"bytes"
+	"errors"
+	"github.com/daedaluz/goconbee/serial"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newPort(t *testing.T, e *Emulator) (*serial.Port, chan serial.CommandID) {
+	t.Helper()
+	local, remote := net.Pipe()
+	go e.Serve(remote)
+	unsolicited := make(chan serial.CommandID, 10)
+	port, err := serial.OpenTransportWithOptions(local, &serial.Handlers{
+		UnsolicitedHandler: func(p *serial.Port, msg serial.CommandID) { unsolicited <- msg },
+		DisconnectHandler:  func(p *serial.Port) {},
+	}, serial.NewOptions().SetHandshake(true).SetCommandTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		port.Close()
+		remote.Close()
+	})
+	return port, unsolicited
+}
+
+func TestHandshake(t *testing.T) {
+	port, _ := newPort(t, New(nil))
+	if fw := port.Firmware(); fw == nil || fw.Platform != serial.Conbee2 || fw.Major != 0x26 {
+		t.Fatal("unexpected firmware", fw)
+	}
+	if port.ProtocolVersion() != defaultProtocolVersion {
+		t.Fatalf("unexpected protocol version 0x%.4x", port.ProtocolVersion())
+	}
+	if !port.Capabilities().Has(serial.CapWatchdog) {
+		t.Fatal("expected watchdog capability")
+	}
+}
+
+func TestParameters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "params.json")
+	store, err := OpenParameterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := newPort(t, New(store))
+	if err := port.WriteParameter(serial.ParamNWKPANID, uint16(0x1234)); err != nil {
+		t.Fatal(err)
+	}
+	if err := port.WriteParameter(serial.ParamZDOSlot, serial.ZDODefaultSlot1, uint8(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := port.WriteParameter(serial.ParamMACAddress, uint64(1)); !errors.Is(err, frame.StatusUnsupported) {
+		t.Fatal("expected", frame.StatusUnsupported, "got", err)
+	}
+
+	store, err = OpenParameterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ = newPort(t, New(store))
+	panID := uint16(0)
+	if err := port.ReadParameter(serial.ParamNWKPANID, &panID); err != nil || panID != 0x1234 {
+		t.Fatalf("expected 0x1234, got 0x%.4x %v", panID, err)
+	}
+	slot := &serial.ZDOParameter{}
+	if err := port.ReadParameter(serial.ParamZDOSlot, slot, uint8(1)); err != nil || slot.String() != serial.ZDODefaultSlot1.String() {
+		t.Fatal("expected", serial.ZDODefaultSlot1, "got", slot, err)
+	}
+	if err := port.ReadParameter(serial.ParamZDOSlot, slot, uint8(0)); !errors.Is(err, frame.StatusInvalidValue) {
+		t.Fatal("expected", frame.StatusInvalidValue, "got", err)
+	}
+}
+
+func TestNetworkState(t *testing.T) {
+	e := New(nil)
+	port, unsolicited := newPort(t, e)
+	if err := port.ChangeNetworkState(serial.NetOffline); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-unsolicited:
+		if changed, ok := msg.(*serial.DeviceStateChanged); !ok || changed.NetworkState != serial.NetOffline {
+			t.Fatal("unexpected", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no state change")
+	}
+	if _, err := port.SendData(1, serial.Address{Mode: serial.AddressNWK, Short: 0x1234, Endpoint: 1}, 0x0104, 0x0006, 1, []byte{1}, 0, 0); !errors.Is(err, frame.StatusNoNetwork) {
+		t.Fatal("expected", frame.StatusNoNetwork, "got", err)
+	}
+}
+
+func TestData(t *testing.T) {
+	e := New(nil)
+	e.SendHandler = func(req *serial.SendDataRequest) uint8 {
+		if req.DstAddress.Short == 0xdead {
+			return 0xd0
+		}
+		return 0
+	}
+	port, unsolicited := newPort(t, e)
+
+	dst := serial.Address{Mode: serial.AddressNWK, Short: 0xdead, Endpoint: 1}
+	resp, err := port.SendData(7, dst, 0x0104, 0x0006, 1, []byte{0x01, 0x02, 0x00}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.DataConfirm || resp.RequestID != 7 {
+		t.Fatal("unexpected response", resp)
+	}
+	select {
+	case msg := <-unsolicited:
+		if changed, ok := msg.(*serial.DeviceStateChanged); !ok || !changed.DataConfirm {
+			t.Fatal("unexpected", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no state change")
+	}
+	confirm, err := port.QuerySendData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if confirm.RequestID != 7 || confirm.DstAddress != dst || confirm.SrcEP != 1 || confirm.Status != 0xd0 || confirm.DataConfirm {
+		t.Fatal("unexpected confirm", confirm)
+	}
+
+	ind := &serial.ApsData{
+		DstAddress: serial.Address{Mode: serial.AddressNWK, Short: 0x0000, Endpoint: 1},
+		SrcAddress: serial.Address{Mode: serial.AddressNWK, Short: 0xdead, Endpoint: 2},
+		ProfileID:  0x0104,
+		ClusterID:  0x0006,
+		Data:       []byte{0x18, 0x01, 0x0b, 0x01, 0x00},
+		LQI:        200,
+		RSSI:       -60,
+	}
+	e.Indicate(ind)
+	state, err := port.GetDeviceState()
+	if err != nil || !state.DataIndication {
+		t.Fatal("expected data indication", state, err)
+	}
+	data, err := port.ReadReceivedData(serial.FlagReadShortSourceAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.SrcAddress != ind.SrcAddress || data.ClusterID != ind.ClusterID || !bytes.Equal(data.Data, ind.Data) || data.LQI != ind.LQI || data.RSSI != ind.RSSI || data.DataIndication {
+		t.Fatal("expected", ind, "got", data)
+	}
+}
+
+func TestDataPump(t *testing.T) {
+	e := New(nil)
+	port, _ := newPort(t, e)
+	indicate := func(n int) {
+		for i := 0; i < n; i++ {
+			e.Indicate(&serial.ApsData{
+				DstAddress: serial.Address{Mode: serial.AddressNWK, Short: 0x0000, Endpoint: 1},
+				SrcAddress: serial.Address{Mode: serial.AddressNWK, Short: 0x1234, Endpoint: 1},
+				ProfileID:  0x0104,
+				ClusterID:  0x0006,
+				Data:       []byte{byte(i)},
+			})
+		}
+	}
+	var pump *serial.DataPump
+	receive := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			select {
+			case data := <-pump.Data():
+				if len(data.Data) != 1 || data.Data[0] != byte(i) {
+					t.Fatal("unexpected data", data)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("data", i, "not pumped")
+			}
+		}
+	}
+
+	// Data queued before the pump starts is found by reading the device state.
+	indicate(3)
+	pump, err := port.StartDataPump(serial.FlagReadShortSourceAddress, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(3)
+	indicate(2)
+	receive(2)
+
+	dst := serial.Address{Mode: serial.AddressNWK, Short: 0x1234, Endpoint: 1}
+	for id := uint8(1); id <= 2; id++ {
+		if _, err := port.SendData(id, dst, 0x0104, 0x0006, 1, []byte{0x01}, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := uint8(1); id <= 2; id++ {
+		select {
+		case confirm := <-pump.Confirms():
+			if confirm.RequestID != id {
+				t.Fatal("expected confirm", id, "got", confirm)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("confirm", id, "not pumped")
+		}
+	}
+
+	port.StopDataPump()
+	if _, ok := <-pump.Data(); ok {
+		t.Fatal("data channel not closed")
+	}
+}
+
+func TestPTY(t *testing.T) {
+	pty, err := OpenPTY()
+	if err != nil {
+		t.Skip("no pty:", err)
+	}
+	defer pty.Close()
+	go New(nil).Serve(pty)
+	port, err := serial.Open(pty.Name(), &serial.Handlers{
+		UnsolicitedHandler: func(p *serial.Port, msg serial.CommandID) {},
+		DisconnectHandler:  func(p *serial.Port) {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+	if _, err := port.ReadFirmwareVersion(); err != nil {
+		t.Fatal(err)
+	}
+}
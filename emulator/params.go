@@ -0,0 +1,192 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/daedaluz/goconbee/serial"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	ErrUnknownParameter = errors.New("unknown parameter")
+	ErrInvalidValue     = errors.New("invalid parameter value")
+)
+
+type parameter struct {
+	// size of the value, 0 if it varies.
+	size int
+	// keySize is the length of the argument that selects one of several values, e.g. the ZDO slot.
+	keySize  int
+	readOnly bool
+}
+
+var parameters = map[serial.ParameterID]parameter{
+	serial.ParamMACAddress:             {size: 8, readOnly: true},
+	serial.ParamNWKPANID:               {size: 2},
+	serial.ParamNWKAddress:             {size: 2, readOnly: true},
+	serial.ParamNWKExtendedPANID:       {size: 8, readOnly: true},
+	serial.ParamAPSDesignedCoordinator: {size: 1},
+	serial.ParamChannelMask:            {size: 4},
+	serial.ParamAPSExtendedPANID:       {size: 8},
+	serial.ParamTrustCenterAddress:     {size: 8},
+	serial.ParamSecurityMode:           {size: 1},
+	serial.ParamZDOSlot:                {keySize: 1},
+	serial.ParamPredefinedNWKPANID:     {size: 1},
+	serial.ParamNetworkKey:             {size: 16},
+	serial.ParamLinkKey:                {size: 24, keySize: 8},
+	serial.ParamCurrentChannel:         {size: 1, readOnly: true},
+	serial.ParamOpenNetwork:            {size: 1},
+	serial.ParamProtocolVersion:        {size: 2, readOnly: true},
+	serial.ParamNWKUpdateID:            {size: 1},
+	serial.ParamWatchdogTTL:            {size: 4},
+	serial.ParamNWKFrameCounter:        {size: 4},
+	serial.ParamAppZDPHandling:         {size: 2},
+}
+
+const (
+	defaultMAC             = 0x00212effff0a0b0c
+	defaultPANID           = 0x1a62
+	defaultChannel         = 11
+	defaultProtocolVersion = 0x010b
+)
+
+// ParameterStore holds the parameters of an emulated stick. A store opened with a path
+// is saved after every change, so the parameters survive a restart of the emulator like
+// they survive a power cycle of a stick.
+type ParameterStore struct {
+	lock   sync.Mutex
+	path   string
+	values map[string][]byte
+}
+
+// NewParameterStore returns a store with the defaults of a freshly flashed coordinator that is kept in memory.
+func NewParameterStore() *ParameterStore {
+	s := &ParameterStore{values: make(map[string][]byte)}
+	u8 := func(x uint8) []byte { return []byte{x} }
+	u16 := func(x uint16) []byte { return binary.LittleEndian.AppendUint16(nil, x) }
+	u32 := func(x uint32) []byte { return binary.LittleEndian.AppendUint32(nil, x) }
+	u64 := func(x uint64) []byte { return binary.LittleEndian.AppendUint64(nil, x) }
+	defaults := map[serial.ParameterID][]byte{
+		serial.ParamMACAddress:             u64(defaultMAC),
+		serial.ParamNWKPANID:               u16(defaultPANID),
+		serial.ParamNWKAddress:             u16(0x0000),
+		serial.ParamNWKExtendedPANID:       u64(defaultMAC),
+		serial.ParamAPSDesignedCoordinator: u8(1),
+		serial.ParamChannelMask:            u32(1 << defaultChannel),
+		serial.ParamAPSExtendedPANID:       u64(0),
+		serial.ParamTrustCenterAddress:     u64(defaultMAC),
+		serial.ParamSecurityMode:           u8(uint8(serial.SecurityNoMasterTCLK)),
+		serial.ParamPredefinedNWKPANID:     u8(0),
+		serial.ParamNetworkKey:             make([]byte, 16),
+		serial.ParamCurrentChannel:         u8(defaultChannel),
+		serial.ParamOpenNetwork:            u8(0),
+		serial.ParamProtocolVersion:        u16(defaultProtocolVersion),
+		serial.ParamNWKUpdateID:            u8(0),
+		serial.ParamWatchdogTTL:            u32(0),
+		serial.ParamNWKFrameCounter:        u32(0),
+		serial.ParamAppZDPHandling:         u16(0),
+	}
+	for id, value := range defaults {
+		s.values[storeKey(id, nil)] = value
+	}
+	return s
+}
+
+// OpenParameterStore returns a store that is saved to path, values already saved there
+// replace the defaults.
+func OpenParameterStore(path string) (*ParameterStore, error) {
+	s := NewParameterStore()
+	s.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	saved := make(map[string]string)
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for key, value := range saved {
+		if s.values[key], err = hex.DecodeString(value); err != nil {
+			return nil, fmt.Errorf("%s: parameter %s: %w", path, key, err)
+		}
+	}
+	return s, nil
+}
+
+// Get returns the value of id, key selects one of several values (the slot of ParamZDOSlot,
+// the mac address of ParamLinkKey) and is part of the value returned.
+func (s *ParameterStore) Get(id serial.ParameterID, key []byte) ([]byte, error) {
+	param, ok := parameters[id]
+	if !ok {
+		return nil, ErrUnknownParameter
+	}
+	if len(key) < param.keySize {
+		return nil, ErrInvalidValue
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	value, ok := s.values[storeKey(id, key[:param.keySize])]
+	if !ok {
+		return nil, ErrInvalidValue
+	}
+	return append([]byte(nil), value...), nil
+}
+
+// Set changes the value of id, for parameters with a key the value starts with the key.
+// Unlike a write from the serial port, Set also changes read only parameters.
+func (s *ParameterStore) Set(id serial.ParameterID, value []byte) error {
+	param, ok := parameters[id]
+	if !ok {
+		return ErrUnknownParameter
+	}
+	if (param.size > 0 && len(value) != param.size) || len(value) <= param.keySize {
+		return ErrInvalidValue
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.values[storeKey(id, value[:param.keySize])] = append([]byte(nil), value...)
+	return s.save()
+}
+
+func (s *ParameterStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	saved := make(map[string]string, len(s.values))
+	for key, value := range s.values {
+		saved[key] = hex.EncodeToString(value)
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// storeKey is the parameter id followed by the key in hex, e.g. 13:01 for ZDO slot 1.
+func storeKey(id serial.ParameterID, key []byte) string {
+	res := fmt.Sprintf("%.2x", uint8(id))
+	if len(key) > 0 {
+		res += ":" + hex.EncodeToString(key)
+	}
+	return res
+}
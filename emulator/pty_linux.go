@@ -0,0 +1,86 @@
+package emulator
+
+import (
+	"fmt"
+	serial "github.com/daedaluz/goserial"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	tiocgptn   = 0x80045430
+	tiocsptlck = 0x40045431
+)
+
+// PTY is a pseudo terminal to serve the emulator on, a serial.Port opens Name like the tty of a stick.
+type PTY struct {
+	master *os.File
+	// slave is kept open, reading the master fails while no one has the slave open.
+	slave *serial.Port
+	name  string
+}
+
+func OpenPTY() (*PTY, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	unlock := int32(0)
+	if err := ioctl(master, tiocsptlck, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("unlock pty: %w", err)
+	}
+	n := uint32(0)
+	if err := ioctl(master, tiocgptn, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("get pty number: %w", err)
+	}
+	name := fmt.Sprintf("/dev/pts/%d", n)
+	slave, err := serial.Open(name, nil)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	if err := slave.MakeRaw(); err != nil {
+		slave.Close()
+		master.Close()
+		return nil, err
+	}
+	return &PTY{master: master, slave: slave, name: name}, nil
+}
+
+func ioctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Name is the path of the terminal to open, e.g. /dev/pts/3.
+func (p *PTY) Name() string {
+	return p.name
+}
+
+func (p *PTY) Read(b []byte) (int, error) {
+	return p.master.Read(b)
+}
+
+func (p *PTY) Write(b []byte) (int, error) {
+	return p.master.Write(b)
+}
+
+func (p *PTY) Close() error {
+	p.slave.Close()
+	return p.master.Close()
+}
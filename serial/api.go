@@ -2,128 +2,186 @@ package serial
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"time"
 )
 
+// execute queues a request and waits for the response to be decoded into res, idempotent
+// requests are retried as set by the retry policy and busy answers as set by the busy retry policy.
+func (p *Port) execute(ctx context.Context, req request, res response) error {
+	retries := p.newRetries(ctx, req.CommandID())
+	for {
+		err := p.executeOnce(ctx, req, res)
+		backoff, retry := retries.next(err)
+		if !retry {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.closed:
+			return ErrClosed
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (p *Port) executeOnce(ctx context.Context, req request, res response) error {
+	cmd := newRequestResponseCommand(req, res, p.commandTimeout(ctx))
+	p.submit(ctx, p.priority(ctx, req.CommandID()), cmd)
+	select {
+	case <-cmd.done():
+	case <-ctx.Done():
+		// A queued command is removed, the response to one in flight is ignored.
+		cmd.complete(ctx.Err())
+		p.queue.remove(cmd)
+	}
+	if err, ok := cmd.wait().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// The methods below block until the stick answers or the command times out, the Context
+// variants also return ctx.Err() as soon as ctx is done.
+
 func (p *Port) ReadFirmwareVersion() (*FirmwareVersion, error) {
+	return p.ReadFirmwareVersionContext(context.Background())
+}
+
+func (p *Port) ReadFirmwareVersionContext(ctx context.Context) (*FirmwareVersion, error) {
 	version := &FirmwareVersion{}
-	cmd := newRequestResponseCommand(&readFirmwareVersionRequest{}, version)
-	p.cmdCh <- cmd
-	res := cmd.wait()
-	if err, ok := res.(error); ok {
+	if err := p.execute(ctx, &readFirmwareVersionRequest{}, version); err != nil {
 		return nil, err
 	}
 	return version, nil
 }
 
 func (p *Port) ReadParameterRaw(param ParameterID) ([]byte, error) {
+	return p.ReadParameterRawContext(context.Background(), param)
+}
+
+func (p *Port) ReadParameterRawContext(ctx context.Context, param ParameterID) ([]byte, error) {
+	if err := p.checkParameter(param); err != nil {
+		return nil, err
+	}
 	paramResp := &ReadParameterResponse{}
-	cmd := newRequestResponseCommand(&readParameterRequest{parameterId: param}, paramResp)
-	p.cmdCh <- cmd
-	res := cmd.wait()
-	if err, ok := res.(error); ok {
+	if err := p.execute(ctx, &readParameterRequest{parameterId: param}, paramResp); err != nil {
 		return nil, err
 	}
 	return paramResp.value, nil
 }
 
 func (p *Port) ReadParameter(param ParameterID, out any, args ...any) error {
+	return p.ReadParameterContext(context.Background(), param, out, args...)
+}
+
+func (p *Port) ReadParameterContext(ctx context.Context, param ParameterID, out any, args ...any) error {
+	if err := p.checkParameter(param); err != nil {
+		return err
+	}
 	paramResp := &ReadParameterResponse{}
+	err := p.execute(ctx, &readParameterRequest{parameterId: param, args: encodeParameter(nil, args...)}, paramResp)
+	decodeParameter(paramResp.value, out)
+	return err
+}
 
+// encodeParameter encodes args followed by value, if it is not nil.
+func encodeParameter(value any, args ...any) []byte {
 	buff := &bytes.Buffer{}
 	for _, arg := range args {
 		binary.Write(buff, binary.LittleEndian, arg)
 	}
+	if encoder, ok := value.(paramEncoder); ok {
+		buff.Write(encoder.encode())
+	} else if value != nil {
+		binary.Write(buff, binary.LittleEndian, value)
+	}
+	return buff.Bytes()
+}
 
-	cmd := newRequestResponseCommand(&readParameterRequest{parameterId: param, args: buff.Bytes()}, paramResp)
-	p.cmdCh <- cmd
-	res := cmd.wait()
-
+func decodeParameter(value []byte, out any) {
 	if o, ok := out.(paramDecoder); ok {
-		o.decode(paramResp.value)
+		o.decode(value)
 	} else {
-		r := bytes.NewReader(paramResp.value)
+		r := bytes.NewReader(value)
 		binary.Read(r, binary.LittleEndian, out)
 	}
-	if err, ok := res.(error); ok {
-		return err
-	}
-	return nil
 }
 
 func (p *Port) WriteParameterRaw(param ParameterID, value []byte) error {
-	resp := &WriteParameterResponse{}
-	cmd := newRequestResponseCommand(&writeParameterRequest{
-		parameterID: param,
-		value:       value,
-	}, resp)
-	p.cmdCh <- cmd
-	res := cmd.wait()
-	if err, ok := res.(error); ok {
+	return p.WriteParameterRawContext(context.Background(), param, value)
+}
+
+func (p *Port) WriteParameterRawContext(ctx context.Context, param ParameterID, value []byte) error {
+	if err := p.checkParameter(param); err != nil {
 		return err
 	}
-	return nil
+	return p.execute(ctx, &writeParameterRequest{
+		parameterID: param,
+		value:       value,
+	}, &WriteParameterResponse{})
 }
 
 func (p *Port) WriteParameter(param ParameterID, value any, args ...any) error {
-	buff := &bytes.Buffer{}
-	for _, arg := range args {
-		binary.Write(buff, binary.LittleEndian, arg)
-	}
-	if encoder, ok := value.(paramEncoder); ok {
-		buff.Write(encoder.encode())
-	} else {
-		binary.Write(buff, binary.LittleEndian, value)
-	}
-	resp := &WriteParameterResponse{}
-	cmd := newRequestResponseCommand(&writeParameterRequest{
-		parameterID: param,
-		value:       buff.Bytes(),
-	}, resp)
-	p.cmdCh <- cmd
-	res := cmd.wait()
-	if err, ok := res.(error); ok {
+	return p.WriteParameterContext(context.Background(), param, value, args...)
+}
+
+func (p *Port) WriteParameterContext(ctx context.Context, param ParameterID, value any, args ...any) error {
+	if err := p.checkParameter(param); err != nil {
 		return err
 	}
-	return nil
+	return p.execute(ctx, &writeParameterRequest{
+		parameterID: param,
+		value:       encodeParameter(value, args...),
+	}, &WriteParameterResponse{})
 }
 
 func (p *Port) GetDeviceState() (*DeviceState, error) {
+	return p.GetDeviceStateContext(context.Background())
+}
+
+func (p *Port) GetDeviceStateContext(ctx context.Context) (*DeviceState, error) {
 	stateResp := &DeviceState{}
-	cmd := newRequestResponseCommand(&deviceStateRequest{}, stateResp)
-	p.cmdCh <- cmd
-	res := cmd.wait()
-	if err, ok := res.(error); ok {
+	if err := p.execute(ctx, &deviceStateRequest{}, stateResp); err != nil {
 		return nil, err
 	}
 	return stateResp, nil
 }
 
 func (p *Port) ChangeNetworkState(state NetworkState) error {
-	resp := &ChangeNetworkStateResponse{}
-	cmd := newRequestResponseCommand(&changeNetworkStateRequest{NetworkState: state}, resp)
-	p.cmdCh <- cmd
-	res := cmd.wait()
-	if err, ok := res.(error); ok {
-		return err
-	}
-	return nil
+	return p.ChangeNetworkStateContext(context.Background(), state)
+}
+
+func (p *Port) ChangeNetworkStateContext(ctx context.Context, state NetworkState) error {
+	return p.execute(ctx, &changeNetworkStateRequest{NetworkState: state}, &ChangeNetworkStateResponse{})
 }
 
 func (p *Port) ReadReceivedData(flags ReadDataFlag) (*ApsData, error) {
+	return p.ReadReceivedDataContext(context.Background(), flags)
+}
+
+func (p *Port) ReadReceivedDataContext(ctx context.Context, flags ReadDataFlag) (*ApsData, error) {
+	if flags != 0 {
+		if err := p.checkCapability(CapDataIndicationFlags); err != nil {
+			return nil, err
+		}
+	}
 	resp := &ApsData{}
-	cmd := newRequestResponseCommand(&apsReadDataRequest{flags: flags}, resp)
-	p.cmdCh <- cmd
-	res := cmd.wait()
-	if err, ok := res.(error); ok {
+	if err := p.execute(ctx, &apsReadDataRequest{flags: flags}, resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
 
 func (p *Port) SendData(reqID uint8, dstAddr Address, profileID, clusterID uint16, srcEP uint8, data []byte, opts TXOptions, radius uint8, srcRoute ...uint16) (*SendDataResponse, error) {
+	return p.SendDataContext(context.Background(), reqID, dstAddr, profileID, clusterID, srcEP, data, opts, radius, srcRoute...)
+}
+
+func (p *Port) SendDataContext(ctx context.Context, reqID uint8, dstAddr Address, profileID, clusterID uint16, srcEP uint8, data []byte, opts TXOptions, radius uint8, srcRoute ...uint16) (*SendDataResponse, error) {
 	resp := &SendDataResponse{}
-	req := &enqueueSendDataRequest{
+	req := &SendDataRequest{
 		RequestID:  reqID,
 		DstAddress: dstAddr,
 		ProfileID:  profileID,
@@ -134,24 +192,22 @@ func (p *Port) SendData(reqID uint8, dstAddr Address, profileID, clusterID uint1
 		Radius:     radius,
 	}
 	if len(srcRoute) > 0 {
+		if err := p.checkCapability(CapSourceRouting); err != nil {
+			return nil, err
+		}
 		req.Flags |= sendDataFlagSourceRouting
 		req.Relay = srcRoute
 	}
-	cmd := newRequestResponseCommand(req, resp)
-	p.cmdCh <- cmd
-	res := cmd.wait()
-	if err, ok := res.(error); ok {
-		return resp, err
-	}
-	return resp, nil
+	return resp, p.execute(ctx, req, resp)
 }
 
 func (p *Port) QuerySendData() (*QuerySendDataResponse, error) {
+	return p.QuerySendDataContext(context.Background())
+}
+
+func (p *Port) QuerySendDataContext(ctx context.Context) (*QuerySendDataResponse, error) {
 	resp := &QuerySendDataResponse{}
-	cmd := newRequestResponseCommand(&querySendDataRequest{}, resp)
-	p.cmdCh <- cmd
-	res := cmd.wait()
-	if err, ok := res.(error); ok {
+	if err := p.execute(ctx, &querySendDataRequest{}, resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
@@ -159,34 +215,28 @@ func (p *Port) QuerySendData() (*QuerySendDataResponse, error) {
 
 // Currently not working.. why?
 func (p *Port) AddNeighbor(nwk uint16, IEEEAddr uint64, macCapabilities MacCapabilities) error {
-	resp := &UpdateNeighborResponse{}
-	cmd := newRequestResponseCommand(&updateNeighborRequest{
+	return p.AddNeighborContext(context.Background(), nwk, IEEEAddr, macCapabilities)
+}
+
+func (p *Port) AddNeighborContext(ctx context.Context, nwk uint16, IEEEAddr uint64, macCapabilities MacCapabilities) error {
+	return p.execute(ctx, &updateNeighborRequest{
 		Action:          actionAdd,
 		NWK:             nwk,
 		IEEEAddr:        IEEEAddr,
 		MacCapabilities: macCapabilities,
-	}, resp)
-	p.cmdCh <- cmd
-	res := cmd.wait()
-	if err, ok := res.(error); ok {
-		return err
-	}
-	return nil
+	}, &UpdateNeighborResponse{})
 }
 
 // Currently not working.. why?
 func (p *Port) RemoveNeighbor(nwk uint16, IEEEAddr uint64, macCapabilities MacCapabilities) error {
-	resp := &UpdateNeighborResponse{}
-	cmd := newRequestResponseCommand(&updateNeighborRequest{
+	return p.RemoveNeighborContext(context.Background(), nwk, IEEEAddr, macCapabilities)
+}
+
+func (p *Port) RemoveNeighborContext(ctx context.Context, nwk uint16, IEEEAddr uint64, macCapabilities MacCapabilities) error {
+	return p.execute(ctx, &updateNeighborRequest{
 		Action:          actionRemove,
 		NWK:             nwk,
 		IEEEAddr:        IEEEAddr,
 		MacCapabilities: macCapabilities,
-	}, resp)
-	p.cmdCh <- cmd
-	res := cmd.wait()
-	if err, ok := res.(error); ok {
-		return err
-	}
-	return nil
+	}, &UpdateNeighborResponse{})
 }
@@ -0,0 +1,79 @@
+// Package capture records the frames of a serial.Port to a file and replays them later,
+// without a stick attached.
+//
+// A capture is a text file with one frame per line:
+//
+//	<offset> <tx|rx> <frame as hex>
+//
+// where offset is the time since the recording started, as formatted by time.Duration.
+// Empty lines and lines starting with # are ignored.
+package capture
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"github.com/daedaluz/goconbee/serial"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"io"
+	"strings"
+	"time"
+)
+
+type Record struct {
+	Offset    time.Duration
+	Direction serial.Direction
+	Frame     frame.Frame
+}
+
+func (r Record) String() string {
+	dir := "tx"
+	if r.Direction == serial.DirectionRX {
+		dir = "rx"
+	}
+	return fmt.Sprintf("%s %s %X", r.Offset, dir, []byte(r.Frame))
+}
+
+func parseRecord(line string) (Record, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return Record{}, fmt.Errorf("expected 3 fields, got %d", len(fields))
+	}
+	offset, err := time.ParseDuration(fields[0])
+	if err != nil {
+		return Record{}, err
+	}
+	var dir serial.Direction
+	switch fields[1] {
+	case "tx":
+		dir = serial.DirectionTX
+	case "rx":
+		dir = serial.DirectionRX
+	default:
+		return Record{}, fmt.Errorf("unknown direction %q", fields[1])
+	}
+	data, err := hex.DecodeString(fields[2])
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Offset: offset, Direction: dir, Frame: data}, nil
+}
+
+// ReadAll reads every record of a capture.
+func ReadAll(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		record, err := parseRecord(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,120 @@
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/daedaluz/goconbee/serial"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func session() string {
+	return strings.Join([]string{
+		"# ReadFirmwareVersion with an unsolicited state change before it",
+		fmt.Sprintf("0s rx %X", []byte(frame.NewFrame(frame.CmdDeviceStateChanged, 3, []byte{0x22}))),
+		fmt.Sprintf("1.5ms tx %X", []byte(frame.NewFrame(frame.CmdVersion, 7, []byte{0, 0, 0, 0}))),
+		fmt.Sprintf("4ms rx %X", []byte(frame.NewFrame(frame.CmdVersion, 7, []byte{0, byte(serial.Conbee2), 0x72, 0x26}))),
+	}, "\n")
+}
+
+func openReplay(t *testing.T, capture string, handlers *serial.Handlers) (*serial.Port, *Replay) {
+	t.Helper()
+	replay, err := NewReplay(strings.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return openTransport(t, replay, handlers, serial.NewOptions()), replay
+}
+
+func openTransport(t *testing.T, conn io.ReadWriteCloser, handlers *serial.Handlers, options *serial.Options) *serial.Port {
+	t.Helper()
+	port, err := serial.OpenTransportWithOptions(conn, handlers, options.SetCommandTimeout(time.Millisecond*200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { port.Close() })
+	return port
+}
+
+func TestReplay(t *testing.T) {
+	changes := make(chan *serial.DeviceStateChanged, 1)
+	replay, err := NewReplay(strings.NewReader(session()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	recorder := NewRecorder(out)
+	received := make(chan struct{})
+	record := recorder.Interceptor()
+	options := serial.NewOptions().AddInterceptor(func(p *serial.Port, ev *serial.FrameEvent) bool {
+		record(p, ev)
+		if ev.Direction == serial.DirectionRX && ev.Frame.CommandID() == frame.CmdDeviceStateChanged {
+			close(received)
+		}
+		return true
+	})
+	port := openTransport(t, replay, &serial.Handlers{
+		UnsolicitedHandler: func(p *serial.Port, msg serial.CommandID) {
+			if x, ok := msg.(*serial.DeviceStateChanged); ok {
+				changes <- x
+			}
+		},
+	}, options)
+
+	// Wait for the recorder to see the unsolicited frame, so it is recorded before the
+	// command is written, as in the capture.
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("state change not recorded")
+	}
+	version, err := port.ReadFirmwareVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Platform != serial.Conbee2 || version.Major != 0x26 {
+		t.Fatal("unexpected version", version)
+	}
+	select {
+	case change := <-changes:
+		if change.NetworkState != serial.NetConnected || !change.FreeSlots {
+			t.Fatal("unexpected state change", change)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("state change not replayed")
+	}
+	<-replay.Done()
+	if err := replay.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The recording of the replay holds the same frames, apart from sequence numbers.
+	expected, _ := ReadAll(strings.NewReader(session()))
+	recorded, err := ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != len(expected) {
+		t.Fatal("expected", expected, "got", recorded)
+	}
+	for i := range recorded {
+		if recorded[i].Direction != expected[i].Direction || !sameFrame(recorded[i].Frame, expected[i].Frame) {
+			t.Fatal("record", i, "expected", expected[i], "got", recorded[i])
+		}
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	port, replay := openReplay(t, session(), nil)
+	if _, err := port.GetDeviceState(); err == nil {
+		t.Fatal("expected the command to time out")
+	}
+	var mismatch *MismatchError
+	if err := replay.Err(); !errors.As(err, &mismatch) || len(mismatch.Mismatches) != 1 {
+		t.Fatal("expected one mismatch, got", err)
+	}
+}
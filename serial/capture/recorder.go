@@ -0,0 +1,61 @@
+package capture
+
+import (
+	"fmt"
+	"github.com/daedaluz/goconbee/serial"
+	"io"
+	"sync"
+	"time"
+)
+
+// Recorder writes frames to a capture, add it to the options of a port with
+//
+//	options.AddInterceptor(recorder.Interceptor())
+//
+// or to an open port with
+//
+//	remove := port.AddInterceptor(recorder.Interceptor())
+type Recorder struct {
+	lock  sync.Mutex
+	w     io.Writer
+	start time.Time
+	err   error
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// Record writes ev to the capture, offsets are relative to the first recorded frame.
+func (r *Recorder) Record(ev *serial.FrameEvent) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if r.start.IsZero() {
+		r.start = ev.Time
+	}
+	record := Record{
+		Offset:    ev.Time.Sub(r.start),
+		Direction: ev.Direction,
+		Frame:     ev.Frame,
+	}
+	_, r.err = fmt.Fprintln(r.w, record)
+	return r.err
+}
+
+// Interceptor records every frame and passes it on unchanged. Write errors
+// stop the recording, they are returned by Err.
+func (r *Recorder) Interceptor() serial.Interceptor {
+	return func(p *serial.Port, ev *serial.FrameEvent) bool {
+		r.Record(ev)
+		return true
+	}
+}
+
+func (r *Recorder) Err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
@@ -0,0 +1,191 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/daedaluz/goconbee/serial"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"github.com/daedaluz/goslip"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const end = 0300
+
+// Replay is a transport for serial.OpenTransport that plays back a capture.
+//
+// Received frames are handed to the port in the order they were recorded. Received frames
+// that follow a sent frame are held back until the port has sent a matching frame. Sent frames
+// match their recorded counterpart if they only differ in sequence number, the sequence numbers
+// of the received frames are changed to what the port used. Frames that don't match are
+// reported by Err.
+type Replay struct {
+	lock       sync.Mutex
+	records    []Record
+	pos        int
+	readBuf    bytes.Buffer
+	writeBuf   []byte
+	seqs       map[uint8]uint8
+	mismatches []error
+	closed     bool
+	notify     chan struct{}
+	done       chan struct{}
+
+	// ReadTimeout makes Read return a timeout when there is nothing to read, so the port
+	// gets to check for timed out commands.
+	ReadTimeout time.Duration
+}
+
+func NewReplay(r io.Reader) (*Replay, error) {
+	records, err := ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayRecords(records), nil
+}
+
+func NewReplayRecords(records []Record) *Replay {
+	replay := &Replay{
+		records:     records,
+		seqs:        make(map[uint8]uint8),
+		notify:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		ReadTimeout: time.Millisecond * 100,
+	}
+	replay.lock.Lock()
+	replay.release()
+	replay.lock.Unlock()
+	return replay
+}
+
+// release queues the received frames up to the next sent frame, r.lock must be held.
+func (r *Replay) release() {
+	for ; r.pos < len(r.records) && r.records[r.pos].Direction == serial.DirectionRX; r.pos++ {
+		f := append(frame.Frame(nil), r.records[r.pos].Frame...)
+		if len(f) >= 3 {
+			if seq, ok := r.seqs[f.SeqNumber()]; ok {
+				f[1] = seq
+				f.UpdateCRC()
+			}
+		}
+		r.readBuf.Write(slip.EncodeToBytes(f))
+	}
+	if r.pos == len(r.records) {
+		select {
+		case <-r.done:
+		default:
+			close(r.done)
+		}
+	}
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Replay) Read(b []byte) (int, error) {
+	for {
+		r.lock.Lock()
+		if r.closed {
+			r.lock.Unlock()
+			return 0, io.EOF
+		}
+		if r.readBuf.Len() > 0 {
+			n, err := r.readBuf.Read(b)
+			r.lock.Unlock()
+			return n, err
+		}
+		r.lock.Unlock()
+		select {
+		case <-r.notify:
+		case <-time.After(r.ReadTimeout):
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (r *Replay) Write(b []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+	for _, x := range b {
+		if x != end {
+			r.writeBuf = append(r.writeBuf, x)
+			continue
+		}
+		packet, err := slip.DecodeFromBytes(append(r.writeBuf, end))
+		r.writeBuf = r.writeBuf[:0]
+		if err != nil {
+			return 0, err
+		}
+		if len(packet) > 0 {
+			r.sent(packet)
+		}
+	}
+	return len(b), nil
+}
+
+// sent matches f against the next recorded frame, r.lock must be held.
+func (r *Replay) sent(f frame.Frame) {
+	if r.pos >= len(r.records) {
+		r.mismatches = append(r.mismatches, fmt.Errorf("unexpected frame %X after the end of the capture", []byte(f)))
+		return
+	}
+	expected := r.records[r.pos].Frame
+	if !sameFrame(expected, f) {
+		r.mismatches = append(r.mismatches, fmt.Errorf("record %d: expected %X, got %X", r.pos, []byte(expected), []byte(f)))
+	} else if len(f) >= 3 {
+		r.seqs[expected.SeqNumber()] = f.SeqNumber()
+	}
+	r.pos++
+	r.release()
+}
+
+// sameFrame compares two frames, ignoring the sequence number and with it the crc.
+func sameFrame(a, b frame.Frame) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	if len(a) < 3 {
+		return bytes.Equal(a, b)
+	}
+	return a[0] == b[0] && bytes.Equal(a[2:len(a)-2], b[2:len(b)-2])
+}
+
+// Done is closed when every record has been played back.
+func (r *Replay) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns an error describing every sent frame that did not match the capture.
+func (r *Replay) Err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.mismatches) == 0 {
+		return nil
+	}
+	return &MismatchError{Mismatches: append([]error(nil), r.mismatches...)}
+}
+
+func (r *Replay) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = true
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+type MismatchError struct {
+	Mismatches []error
+}
+
+func (m *MismatchError) Error() string {
+	return fmt.Sprintf("%d frames did not match the capture, first: %s", len(m.Mismatches), m.Mismatches[0])
+}
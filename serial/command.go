@@ -1,105 +1,217 @@
 package serial
 
 import (
-	"fmt"
+	"errors"
 	"github.com/daedaluz/goconbee/serial/frame"
-	"log"
 	"sync"
 	"time"
 )
 
 type command interface {
-	init(c *Port) error
-	handle(c *Port, resultCh chan<- any, frame frame.Frame, err error) bool
-	ping(now time.Time, resultCh chan<- any)
-	finish(c *Port, x any)
-	exit(c *Port)
-	onError(err error)
+	CommandID
+	// init writes the command with sequence number seq, after a disconnect it is called
+	// again with the same seq.
+	init(c *Port, seq uint8) error
+	handle(c *Port, frame frame.Frame) bool
+	complete(x any)
+	done() <-chan struct{}
+	// onComplete registers f to be called once the command has completed, in the order
+	// of registration.
+	onComplete(f func())
 }
 
-type commandHandler struct {
-	exitCh         chan bool
-	doneCh         chan any
-	lock           sync.Mutex
-	currentCommand command
-	c              *Port
+// inflightKey identifies the response to a command in flight.
+type inflightKey struct {
+	cmd frame.Command
+	seq uint8
 }
 
-func (m *commandHandler) ping(now time.Time) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	if m.currentCommand != nil {
-		m.currentCommand.ping(now, m.doneCh)
-	}
-}
+// maxWindow is the number of sequence numbers, no more commands than that can be told apart.
+const maxWindow = 256
 
-func newCommandHandler(c *Port) *commandHandler {
-	return &commandHandler{
-		c:      c,
-		lock:   sync.Mutex{},
-		exitCh: make(chan bool, 1),
-	}
-}
+// seqHoldTime is how long the sequence number of a command that timed out or was canceled
+// is held back, a response later than that is not expected.
+const seqHoldTime = time.Second * 10
 
-func (m *commandHandler) handle(frame frame.Frame, err error) bool {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	if m.currentCommand != nil {
-		return m.currentCommand.handle(m.c, m.doneCh, frame, err)
-	}
-	return false
+// heldSeq is a sequence number that may still be answered for cmd.
+type heldSeq struct {
+	cmd   frame.Command
+	until time.Time
 }
 
-func (m *commandHandler) start() {
+// dispatch starts queued commands while fewer than the window are in flight.
+func (p *Port) dispatch() {
 	for {
-		select {
-		case <-m.exitCh:
-			return
-		case cmd := <-m.c.cmdCh:
-			if m.processCommand(cmd) {
+		if !p.hasRoom() {
+			select {
+			case <-p.closed:
 				return
+			case <-p.room:
 			}
+			continue
 		}
+		cmd := p.queue.pop()
+		if cmd == nil {
+			select {
+			case <-p.closed:
+				return
+			case <-p.queue.ready:
+			}
+			continue
+		}
+		p.start(cmd)
 	}
 }
-func (m *commandHandler) processCommand(cmd command) bool {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Println(err)
-		}
-	}()
-	m.doneCh = make(chan any)
-	defer close(m.doneCh)
-	m.lock.Lock()
-	m.currentCommand = cmd
-	m.lock.Unlock()
-	if err := cmd.init(m.c); err != nil {
-		cmd.onError(err)
-		m.lock.Lock()
-		m.currentCommand = nil
-		m.lock.Unlock()
-		return false
-	}
-	exit := false
+
+func (p *Port) start(cmd command) {
 	select {
-	case <-m.exitCh:
-		m.lock.Lock()
-		if m.currentCommand != nil {
-			cmd.exit(m.c)
+	case <-cmd.done():
+		// Cancelled while it was queued.
+		return
+	default:
+	}
+	p.inflightLock.Lock()
+	if p.closing.Load() {
+		p.inflightLock.Unlock()
+		cmd.complete(ErrClosed)
+		return
+	}
+	key := inflightKey{cmd: cmd.CommandID(), seq: p.allocSeq()}
+	p.inflight[key] = cmd
+	p.inflightLock.Unlock()
+	cmd.onComplete(func() { p.release(key, cmd) })
+	// While the port recovers a failed write is repeated by unpark, the command times out
+	// if the device is not back in time.
+	if err := cmd.init(p, key.seq); err != nil && (errors.Is(err, ErrDropped) || !p.recovering()) {
+		cmd.complete(err)
+	}
+}
+
+// allocSeq returns the next sequence number that is not in use, p.inflightLock must be
+// held and fewer than maxWindow commands be in flight. Sequence numbers that are held
+// back, because a late response to an earlier command may still come in, are only
+// used when there is no other one.
+func (p *Port) allocSeq() uint8 {
+	now := time.Now()
+	seq, free := p.nextSeq, false
+	for i := 0; i < maxWindow; i++ {
+		x := p.nextSeq + uint8(i)
+		if p.seqInUse[x] {
+			continue
+		}
+		if !free {
+			seq, free = x, true
+		}
+		if p.seqHeld[x].until.Before(now) {
+			seq = x
+			break
 		}
-		m.lock.Unlock()
-		exit = true
-	case x := <-m.doneCh:
-		if err, ok := x.(Error); ok {
-			cmd.onError(err)
-		} else {
-			cmd.finish(m.c, x)
+	}
+	p.seqInUse[seq] = true
+	p.seqHeld[seq] = heldSeq{}
+	p.nextSeq = seq + 1
+	return seq
+}
+
+// release frees the sequence number of a completed command, it is held back until its
+// response is seen by seqAnswered.
+func (p *Port) release(key inflightKey, cmd command) {
+	p.inflightLock.Lock()
+	if p.inflight[key] == cmd {
+		delete(p.inflight, key)
+		delete(p.parked, key)
+		p.seqInUse[key.seq] = false
+		p.seqHeld[key.seq] = heldSeq{cmd: key.cmd, until: time.Now().Add(seqHoldTime)}
+	}
+	p.inflightLock.Unlock()
+	p.wakeDispatch()
+}
+
+// seqAnswered stops holding back the sequence number of f, once the response to the
+// command that last used it has been read.
+func (p *Port) seqAnswered(f frame.Frame) {
+	p.inflightLock.Lock()
+	defer p.inflightLock.Unlock()
+	if held := &p.seqHeld[f.SeqNumber()]; held.cmd == f.CommandID() {
+		*held = heldSeq{}
+	}
+}
+
+func (p *Port) wakeDispatch() {
+	select {
+	case p.room <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Port) hasRoom() bool {
+	p.inflightLock.Lock()
+	defer p.inflightLock.Unlock()
+	return len(p.inflight)-len(p.parked) < p.window
+}
+
+// inflightCommand returns the command f is the response to, or nil.
+func (p *Port) inflightCommand(f frame.Frame) command {
+	p.inflightLock.Lock()
+	defer p.inflightLock.Unlock()
+	return p.inflight[inflightKey{cmd: f.CommandID(), seq: f.SeqNumber()}]
+}
+
+// inflightCommands returns the commands in flight by their key.
+func (p *Port) inflightCommands() map[inflightKey]command {
+	p.inflightLock.Lock()
+	defer p.inflightLock.Unlock()
+	res := make(map[inflightKey]command, len(p.inflight))
+	for key, cmd := range p.inflight {
+		res[key] = cmd
+	}
+	return res
+}
+
+// park sets the commands in flight aside after the port has recovered from a disconnect,
+// see Port.parked.
+func (p *Port) park() {
+	p.inflightLock.Lock()
+	for key := range p.inflight {
+		p.parked[key] = true
+	}
+	p.inflightLock.Unlock()
+	p.wakeDispatch()
+}
+
+// unpark sends the parked commands that are still in flight again.
+func (p *Port) unpark() {
+	p.inflightLock.Lock()
+	resend := make(map[inflightKey]command, len(p.parked))
+	for key := range p.parked {
+		resend[key] = p.inflight[key]
+	}
+	p.parked = make(map[inflightKey]bool)
+	p.inflightLock.Unlock()
+	for key, cmd := range resend {
+		if err := cmd.init(p, key.seq); err != nil {
+			cmd.complete(err)
 		}
 	}
-	m.lock.Lock()
-	m.currentCommand = nil
-	m.lock.Unlock()
-	return exit
+}
+
+// SetWindow sets how many commands may be in flight at once, from 1 to 256. When the window
+// shrinks the commands in flight are completed before new ones are started.
+func (p *Port) SetWindow(n int) {
+	if n < 1 {
+		n = 1
+	} else if n > maxWindow {
+		n = maxWindow
+	}
+	p.inflightLock.Lock()
+	p.window = n
+	p.inflightLock.Unlock()
+	p.wakeDispatch()
+}
+
+// Deprecated: SetNHandlers is SetWindow.
+func (p *Port) SetNHandlers(n int) {
+	p.SetWindow(n)
 }
 
 type CommandID interface {
@@ -116,70 +228,114 @@ type response interface {
 	decode(f frame.Frame) error
 }
 
+// decodeResponse decodes f into res, a status other than frame.StatusSuccess is returned as a StatusError.
+func decodeResponse(res response, f frame.Frame) error {
+	if status := f.Status(); status != frame.StatusSuccess {
+		return &StatusError{Command: f.CommandID(), Status: status, Frame: f}
+	}
+	return res.decode(f)
+}
+
 type requestResponseCommand struct {
-	start  time.Time
-	req    request
-	res    response
-	seq    uint8
-	doneCh chan any
+	timeout  time.Duration
+	req      request
+	res      response
+	seq      uint8
+	lock     sync.Mutex
+	timer    *time.Timer
+	hooks    []func()
+	doneCh   chan struct{}
+	doneOnce sync.Once
+	result   any
+}
+
+func (g *requestResponseCommand) CommandID() frame.Command {
+	return g.req.CommandID()
 }
 
-func (g *requestResponseCommand) init(c *Port) error {
-	g.start = time.Now()
-	g.seq = c.getSeqNumber()
+// init writes the request and starts the timeout, which starts over if the request is sent again.
+// The timeout is started even if the write fails, as a command that is held back during
+// recovery must not wait for the device forever.
+func (g *requestResponseCommand) init(c *Port, seq uint8) error {
+	g.seq = seq
 	f := g.req.encode(g.seq)
-	if err := c.writeFrame(f); err != nil {
-		return err
+	err := c.writeFrame(f)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.timer != nil {
+		g.timer.Stop()
 	}
-	return nil
+	g.timer = time.AfterFunc(g.timeout, func() {
+		select {
+		case <-g.doneCh:
+			return
+		default:
+		}
+		c.commandTimedOut()
+		g.complete(ErrTimeout)
+	})
+	return err
 }
 
-func (g *requestResponseCommand) handle(c *Port, resultCh chan<- any, f frame.Frame, err error) bool {
+func (g *requestResponseCommand) handle(c *Port, f frame.Frame) bool {
 	if f.CommandID() == g.req.CommandID() && f.SeqNumber() == g.seq {
-		if err := g.res.decode(f); err != nil {
-			resultCh <- err
+		c.commandSucceeded()
+		if err := decodeResponse(g.res, f); err != nil {
+			g.complete(err)
 			return true
 		}
-		resultCh <- g.res
+		c.observeDataFlags(g.res)
+		g.complete(g.res)
 		return true
 	}
 	return false
 }
 
-func (g *requestResponseCommand) ping(now time.Time, resultCh chan<- any) {
-	if now.Sub(g.start) > time.Second*7 {
-		resultCh <- fmt.Errorf("command timeout")
-	}
-}
-
-func (g *requestResponseCommand) finish(c *Port, x any) {
-	g.doneCh <- x
-	close(g.doneCh)
+// complete sets the result of the command, only the first result counts.
+func (g *requestResponseCommand) complete(x any) {
+	g.doneOnce.Do(func() {
+		g.lock.Lock()
+		if g.timer != nil {
+			g.timer.Stop()
+		}
+		g.result = x
+		close(g.doneCh)
+		hooks := g.hooks
+		g.hooks = nil
+		g.lock.Unlock()
+		for _, f := range hooks {
+			f()
+		}
+	})
 }
 
-func (g *requestResponseCommand) exit(c *Port) {
-	g.doneCh <- fmt.Errorf("exit received before finished")
-	close(g.doneCh)
+func (g *requestResponseCommand) onComplete(f func()) {
+	g.lock.Lock()
+	select {
+	case <-g.doneCh:
+		g.lock.Unlock()
+		f()
+		return
+	default:
+	}
+	g.hooks = append(g.hooks, f)
+	g.lock.Unlock()
 }
 
-func (g *requestResponseCommand) onError(err error) {
-	g.doneCh <- err
-	close(g.doneCh)
+func (g *requestResponseCommand) done() <-chan struct{} {
+	return g.doneCh
 }
 
-func (g *requestResponseCommand) wait() (res any) {
-	defer func() {
-		if err := recover(); err != nil {
-			res = nil
-		}
-	}()
-	return <-g.doneCh
+func (g *requestResponseCommand) wait() any {
+	<-g.doneCh
+	return g.result
 }
 
-func newRequestResponseCommand(in request, out response) *requestResponseCommand {
+func newRequestResponseCommand(in request, out response, timeout time.Duration) *requestResponseCommand {
 	return &requestResponseCommand{
-		req:    in,
-		res:    out,
-		doneCh: make(chan any),
+		timeout: timeout,
+		req:     in,
+		res:     out,
+		doneCh:  make(chan struct{}),
 	}
 }
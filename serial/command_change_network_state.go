@@ -25,9 +25,9 @@ func (c *ChangeNetworkStateResponse) CommandID() frame.Command {
 }
 
 func (c *ChangeNetworkStateResponse) decode(f frame.Frame) error {
-	c.NetworkState = NetworkState(f.Data()[0])
-	if f.Status() != frame.StatusSuccess {
-		return f.Status()
+	if err := checkLength(f, 1); err != nil {
+		return err
 	}
+	c.NetworkState = NetworkState(f.Data()[0])
 	return nil
 }
@@ -28,15 +28,15 @@ func (d *DeviceState) CommandID() frame.Command {
 }
 
 func (d *DeviceState) decode(f frame.Frame) error {
+	if err := checkLength(f, 1); err != nil {
+		return err
+	}
 	data := f.Data()
 	d.NetworkState = NetworkState(data[0] & 0b00000011)
 	d.DataConfirm = data[0]&0b00000100 > 0
 	d.DataIndication = data[0]&0b00001000 > 0
 	d.ConfigurationChanged = data[0]&0b00010000 > 0
 	d.FreeSlots = data[0]&0b00100000 > 0
-	if f.Status() != frame.StatusSuccess {
-		return f.Status()
-	}
 	return nil
 }
 
@@ -53,14 +53,14 @@ func (d *DeviceStateChanged) CommandID() frame.Command {
 }
 
 func (d *DeviceStateChanged) decode(f frame.Frame) error {
+	if err := checkLength(f, 1); err != nil {
+		return err
+	}
 	data := f.Data()
 	d.NetworkState = NetworkState(data[0] & 0b00000011)
 	d.DataConfirm = data[0]&0b00000100 > 0
 	d.DataIndication = data[0]&0b00001000 > 0
 	d.ConfigurationChanged = data[0]&0b00010000 > 0
 	d.FreeSlots = data[0]&0b00100000 > 0
-	if f.Status() != frame.StatusSuccess {
-		return f.Status()
-	}
 	return nil
 }
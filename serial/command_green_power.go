@@ -28,6 +28,9 @@ func (g *GreenPower) CommandID() frame.Command {
 }
 
 func (g *GreenPower) decode(f frame.Frame) error {
+	if err := checkLength(f, 10); err != nil {
+		return err
+	}
 	data := f.Data()
 	log.Printf("%X %X %X", data[0:8], data[8:10], data[10:])
 	r := bytes.NewReader(data)
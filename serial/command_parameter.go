@@ -32,12 +32,12 @@ func (r *ReadParameterResponse) CommandID() frame.Command {
 }
 
 func (r *ReadParameterResponse) decode(f frame.Frame) error {
+	if err := checkLength(f, 3); err != nil {
+		return err
+	}
 	data := f.Data()
 	r.parameterID = ParameterID(data[0])
 	r.value = data[3:]
-	if f.Status() != frame.StatusSuccess {
-		return f.Status()
-	}
 	return nil
 }
 
@@ -67,10 +67,9 @@ func (w *WriteParameterResponse) CommandID() frame.Command {
 }
 
 func (w *WriteParameterResponse) decode(f frame.Frame) error {
-	status := f.Status()
-	w.parameterID = ParameterID(f.Data()[1])
-	if status != frame.StatusSuccess {
-		return status
+	if err := checkLength(f, 2); err != nil {
+		return err
 	}
+	w.parameterID = ParameterID(f.Data()[1])
 	return nil
 }
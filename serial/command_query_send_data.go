@@ -36,6 +36,9 @@ func (q *QuerySendDataResponse) CommandID() frame.Command {
 }
 
 func (q *QuerySendDataResponse) decode(f frame.Frame) error {
+	if err := checkLength(f, 5); err != nil {
+		return err
+	}
 	r := bytes.NewReader(f.Data())
 	r.Seek(2, io.SeekCurrent)
 	b, _ := r.ReadByte()
@@ -45,6 +48,7 @@ func (q *QuerySendDataResponse) decode(f frame.Frame) error {
 	q.ConfigurationChanged = b&0b00010000 > 0
 	q.FreeSlots = b&0b00100000 > 0
 
+	q.RequestID, _ = r.ReadByte()
 	binary.Read(r, binary.LittleEndian, &q.DstAddress.Mode)
 	switch q.DstAddress.Mode {
 	case AddressGroup, AddressNWK:
@@ -62,8 +66,5 @@ func (q *QuerySendDataResponse) decode(f frame.Frame) error {
 	}
 	binary.Read(r, binary.LittleEndian, &q.SrcEP)
 	binary.Read(r, binary.LittleEndian, &q.Status)
-	if f.Status() != frame.StatusSuccess {
-		return f.Status()
-	}
 	return nil
 }
@@ -3,6 +3,7 @@ package serial
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"github.com/daedaluz/goconbee/serial/frame"
 	"io"
 )
@@ -49,9 +50,23 @@ func (a *ApsData) CommandID() frame.Command {
 	return frame.CmdAPSDataIndication
 }
 
+// DecodeApsData decodes a data indication frame read from the stick, e.g. as seen by an Interceptor.
+func DecodeApsData(f frame.Frame) (*ApsData, error) {
+	if f.CommandID() != frame.CmdAPSDataIndication {
+		return nil, newDecodeError(f, fmt.Errorf("not a data indication"))
+	}
+	a := &ApsData{}
+	if err := decodeResponse(a, f); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
 func (a *ApsData) decode(f frame.Frame) error {
-	data := f.Data()
-	r := bytes.NewReader(data)
+	if err := checkLength(f, 3); err != nil {
+		return err
+	}
+	r := bytes.NewReader(f.Data())
 	r.Seek(2, io.SeekCurrent)
 	b, _ := r.ReadByte()
 	a.NetworkState = NetworkState(b & 0b00000011)
@@ -73,7 +88,7 @@ func (a *ApsData) decode(f frame.Frame) error {
 	binary.Read(r, binary.LittleEndian, &a.DstAddress.Endpoint)
 
 	binary.Read(r, binary.LittleEndian, &a.SrcAddress.Mode)
-	switch a.DstAddress.Mode {
+	switch a.SrcAddress.Mode {
 	case AddressGroup, AddressNWK:
 		binary.Read(r, binary.LittleEndian, &a.SrcAddress.Short)
 	case AddressIEEE:
@@ -87,17 +102,18 @@ func (a *ApsData) decode(f frame.Frame) error {
 	binary.Read(r, binary.LittleEndian, &a.ProfileID)
 	binary.Read(r, binary.LittleEndian, &a.ClusterID)
 	asduLen := uint16(0)
-	binary.Read(r, binary.LittleEndian, &asduLen)
+	if err := binary.Read(r, binary.LittleEndian, &asduLen); err != nil {
+		return newDecodeError(f, io.ErrUnexpectedEOF)
+	}
 
 	a.Data = make([]byte, asduLen)
-	r.Read(a.Data)
+	if _, err := io.ReadFull(r, a.Data); err != nil {
+		return newDecodeError(f, io.ErrUnexpectedEOF)
+	}
 
 	binary.Read(r, binary.LittleEndian, &a.LastHop)
 	a.LQI, _ = r.ReadByte()
 	r.Seek(4, io.SeekCurrent)
 	binary.Read(r, binary.LittleEndian, &a.RSSI)
-	if f.Status() != frame.StatusSuccess {
-		return f.Status()
-	}
 	return nil
 }
@@ -3,14 +3,17 @@ package serial
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"github.com/daedaluz/goconbee/serial/frame"
+	"io"
 )
 
 type TXOptions uint8
 
 const TXOptUseAPSAck = TXOptions(0x04)
 
-type enqueueSendDataRequest struct {
+// SendDataRequest is the request written by Port.SendData.
+type SendDataRequest struct {
 	Flags      sendDataFlags
 	RequestID  uint8
 	DstAddress Address
@@ -23,11 +26,11 @@ type enqueueSendDataRequest struct {
 	Relay      []uint16
 }
 
-func (e *enqueueSendDataRequest) CommandID() frame.Command {
+func (e *SendDataRequest) CommandID() frame.Command {
 	return frame.CmdAPSDataRequest
 }
 
-func (e *enqueueSendDataRequest) encode(seqNumber uint8) frame.Frame {
+func (e *SendDataRequest) encode(seqNumber uint8) frame.Frame {
 	payloadBuff := &bytes.Buffer{}
 	payloadBuff.WriteByte(e.RequestID)
 	payloadBuff.WriteByte(byte(e.Flags))
@@ -53,7 +56,7 @@ func (e *enqueueSendDataRequest) encode(seqNumber uint8) frame.Frame {
 	payloadBuff.WriteByte(byte(e.Options))
 	payloadBuff.WriteByte(e.Radius)
 
-	if e.Flags&0x02 > 0 {
+	if e.Flags&sendDataFlagSourceRouting > 0 {
 		payloadBuff.WriteByte(byte(len(e.Relay)))
 		for _, x := range e.Relay {
 			binary.Write(payloadBuff, binary.LittleEndian, x)
@@ -67,6 +70,51 @@ func (e *enqueueSendDataRequest) encode(seqNumber uint8) frame.Frame {
 	return frame.NewFrame(frame.CmdAPSDataRequest, seqNumber, dataBuff.Bytes())
 }
 
+// DecodeSendDataRequest decodes a request frame written by Port.SendData, e.g. as seen by an Interceptor.
+func DecodeSendDataRequest(f frame.Frame) (*SendDataRequest, error) {
+	if f.CommandID() != frame.CmdAPSDataRequest {
+		return nil, newDecodeError(f, fmt.Errorf("not a send data request"))
+	}
+	e := &SendDataRequest{}
+	r := bytes.NewReader(f.Data())
+	r.Seek(2, io.SeekCurrent)
+	e.RequestID, _ = r.ReadByte()
+	flags, _ := r.ReadByte()
+	e.Flags = sendDataFlags(flags)
+	binary.Read(r, binary.LittleEndian, &e.DstAddress.Mode)
+	switch e.DstAddress.Mode {
+	case AddressGroup, AddressNWK:
+		binary.Read(r, binary.LittleEndian, &e.DstAddress.Short)
+	case AddressIEEE:
+		binary.Read(r, binary.LittleEndian, &e.DstAddress.Extended)
+	case AddressNWKAndIEEE:
+		binary.Read(r, binary.LittleEndian, &e.DstAddress.Short)
+		binary.Read(r, binary.LittleEndian, &e.DstAddress.Extended)
+	}
+	switch e.DstAddress.Mode {
+	case AddressNWK, AddressIEEE, AddressNWKAndIEEE:
+		binary.Read(r, binary.LittleEndian, &e.DstAddress.Endpoint)
+	}
+	binary.Read(r, binary.LittleEndian, &e.ProfileID)
+	binary.Read(r, binary.LittleEndian, &e.ClusterID)
+	binary.Read(r, binary.LittleEndian, &e.SrcEP)
+	asduLen := uint16(0)
+	binary.Read(r, binary.LittleEndian, &asduLen)
+	e.Data = make([]byte, asduLen)
+	if _, err := io.ReadFull(r, e.Data); err != nil {
+		return nil, newDecodeError(f, io.ErrUnexpectedEOF)
+	}
+	options, _ := r.ReadByte()
+	e.Options = TXOptions(options)
+	e.Radius, _ = r.ReadByte()
+	if e.Flags&sendDataFlagSourceRouting > 0 {
+		n, _ := r.ReadByte()
+		e.Relay = make([]uint16, n)
+		binary.Read(r, binary.LittleEndian, e.Relay)
+	}
+	return e, nil
+}
+
 type SendDataResponse struct {
 	NetworkState         NetworkState
 	DataConfirm          bool
@@ -81,6 +129,9 @@ func (e *SendDataResponse) CommandID() frame.Command {
 }
 
 func (e *SendDataResponse) decode(f frame.Frame) error {
+	if err := checkLength(f, 4); err != nil {
+		return err
+	}
 	data := f.Data()
 	b := data[2]
 	e.NetworkState = NetworkState(b & 0b00000011)
@@ -89,8 +140,5 @@ func (e *SendDataResponse) decode(f frame.Frame) error {
 	e.ConfigurationChanged = b&0b00010000 > 0
 	e.FreeSlots = b&0b00100000 > 0
 	e.RequestID = data[3]
-	if f.Status() != frame.StatusSuccess {
-		return f.Status()
-	}
 	return nil
 }
@@ -0,0 +1,93 @@
+package serial
+
+import (
+	"errors"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"sync"
+	"testing"
+	"time"
+)
+
+func deviceStateResponse(f frame.Frame) frame.Frame {
+	return statusFrame(frame.CmdDeviceState, f.SeqNumber(), frame.StatusSuccess, []byte{byte(NetConnected), 0, 0})
+}
+
+func TestWindow(t *testing.T) {
+	const window = 4
+	var held []frame.Frame
+	// The fake only answers once the whole window is in flight, and in reverse order.
+	port, _ := newFakeStick(t, NewOptions().SetWindow(window), nil, func(f frame.Frame) []frame.Frame {
+		held = append(held, f)
+		if len(held) < window {
+			return nil
+		}
+		var res []frame.Frame
+		for i := len(held) - 1; i >= 0; i-- {
+			res = append(res, deviceStateResponse(held[i]))
+		}
+		held = nil
+		return res
+	})
+	wg := sync.WaitGroup{}
+	errs := make(chan error, window)
+	for i := 0; i < window; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := port.GetDeviceState()
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSequenceNumbers(t *testing.T) {
+	seen := make(map[uint8]int)
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+		seen[f.SeqNumber()]++
+		return []frame.Frame{deviceStateResponse(f)}
+	})
+	for i := 0; i < 300; i++ {
+		if _, err := port.GetDeviceState(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(seen) != 256 {
+		t.Fatal("expected all 256 sequence numbers to be used, got", len(seen))
+	}
+}
+
+func TestLateResponse(t *testing.T) {
+	var late frame.Frame
+	answered := 0
+	port, _ := newFakeStick(t, NewOptions().SetCommandTimeout(time.Millisecond*100), nil, func(f frame.Frame) []frame.Frame {
+		if late == nil {
+			// The first command times out, its response comes in once every other
+			// sequence number has been used.
+			late = statusFrame(frame.CmdDeviceState, f.SeqNumber(), frame.StatusSuccess, []byte{byte(NetOffline), 0, 0})
+			return nil
+		}
+		if answered++; answered == maxWindow {
+			return []frame.Frame{late, deviceStateResponse(f)}
+		}
+		return []frame.Frame{deviceStateResponse(f)}
+	})
+	if _, err := port.GetDeviceState(); !errors.Is(err, ErrTimeout) {
+		t.Fatal("expected", ErrTimeout, "got", err)
+	}
+	for i := 0; i < maxWindow; i++ {
+		state, err := port.GetDeviceState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.NetworkState != NetConnected {
+			t.Fatal("command", i, "was completed by the late response to an earlier one")
+		}
+	}
+}
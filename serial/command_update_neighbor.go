@@ -36,8 +36,5 @@ func (a *UpdateNeighborResponse) CommandID() frame.Command {
 
 func (a *UpdateNeighborResponse) decode(f frame.Frame) error {
 	a.Data = f.Data()
-	if f.Status() != frame.StatusSuccess {
-		return f.Status()
-	}
 	return nil
 }
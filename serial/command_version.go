@@ -26,12 +26,12 @@ func (r *FirmwareVersion) CommandID() frame.Command {
 }
 
 func (r *FirmwareVersion) decode(f frame.Frame) error {
+	if err := checkLength(f, 4); err != nil {
+		return err
+	}
 	data := f.Data()
 	r.Major = data[3]
 	r.Minor = data[2]
 	r.Platform = Platform(data[1])
-	if f.Status() != frame.StatusSuccess {
-		return f.Status()
-	}
 	return nil
 }
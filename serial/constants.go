@@ -1,6 +1,6 @@
 package serial
 
-//go:generate stringer -output=strings.go -type=Platform,NetworkState,ParameterID,AddressMode,SecurityMode,PANIDMode,MacCapabilities
+//go:generate stringer -output=strings.go -type=Platform,NetworkState,ParameterID,AddressMode,SecurityMode,PANIDMode,MacCapabilities,State,Direction,Priority
 
 type Platform byte
 
@@ -0,0 +1,171 @@
+package serial
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	vendorDresden = 0x1cf1
+	vendorFTDI    = 0x0403
+
+	productConbee2 = 0x0030
+	productFT230X  = 0x6015
+)
+
+// Adapter is a stick found by Discover.
+type Adapter struct {
+	// Path is the tty of the adapter, e.g. /dev/ttyACM0
+	Path string
+	// ByID is the stable /dev/serial/by-id link to Path, if there is one.
+	ByID      string
+	Serial    string
+	Model     string
+	VendorID  uint16
+	ProductID uint16
+	// Platform is a guess based on the usb ids, the firmware reports the real one. It is
+	// zero when the ids tell nothing, like for a RaspBee.
+	Platform Platform
+}
+
+// Discover lists the ConBee, ConBee II, ConBee III and RaspBee adapters attached to this machine.
+// ConBee sticks are identified by their usb vendor and product id. A RaspBee can not be told
+// apart from any other device on the uart of a Raspberry Pi, so the uart on the gpio header
+// is reported as a candidate on one. That is /dev/serial0 where the link exists, otherwise
+// /dev/ttyAMA0 and /dev/ttyS0 are both candidates.
+func Discover() ([]Adapter, error) {
+	return discover("/")
+}
+
+func discover(root string) ([]Adapter, error) {
+	entries, err := os.ReadDir(filepath.Join(root, "sys/class/tty"))
+	if err != nil {
+		return nil, err
+	}
+	byID := readByID(root)
+	raspbee := raspbeeUARTs(root)
+	adapters := make([]Adapter, 0, 2)
+	for _, entry := range entries {
+		name := entry.Name()
+		if adapter, ok := usbAdapter(root, name); ok {
+			adapter.ByID = byID[name]
+			adapters = append(adapters, adapter)
+		} else if path, ok := raspbee[name]; ok {
+			adapters = append(adapters, Adapter{
+				Path:  path,
+				ByID:  byID[name],
+				Model: "RaspBee",
+			})
+		}
+	}
+	sort.Slice(adapters, func(i, j int) bool {
+		return adapters[i].Path < adapters[j].Path
+	})
+	return adapters, nil
+}
+
+func usbAdapter(root, name string) (Adapter, bool) {
+	device, err := filepath.EvalSymlinks(filepath.Join(root, "sys/class/tty", name, "device"))
+	if err != nil {
+		return Adapter{}, false
+	}
+	sys, err := filepath.EvalSymlinks(filepath.Join(root, "sys"))
+	if err != nil {
+		return Adapter{}, false
+	}
+	// The tty belongs to an usb interface, the ids are found on the usb device above it.
+	usbDevice := ""
+	for dir, i := device, 0; i < 4 && strings.HasPrefix(dir, sys); dir, i = filepath.Dir(dir), i+1 {
+		if _, err := os.Stat(filepath.Join(dir, "idVendor")); err == nil {
+			usbDevice = dir
+			break
+		}
+	}
+	if usbDevice == "" {
+		return Adapter{}, false
+	}
+	vendor, err1 := strconv.ParseUint(readSysfs(usbDevice, "idVendor"), 16, 16)
+	product, err2 := strconv.ParseUint(readSysfs(usbDevice, "idProduct"), 16, 16)
+	if err1 != nil || err2 != nil {
+		return Adapter{}, false
+	}
+	adapter := Adapter{
+		Path:      "/dev/" + name,
+		Serial:    readSysfs(usbDevice, "serial"),
+		Model:     readSysfs(usbDevice, "product"),
+		VendorID:  uint16(vendor),
+		ProductID: uint16(product),
+	}
+	switch {
+	case vendor == vendorDresden && product == productConbee2:
+		adapter.Platform = Conbee2
+		if adapter.Model == "" {
+			adapter.Model = "ConBee II"
+		}
+	case vendor == vendorFTDI && product == productFT230X:
+		// The first ConBee and the ConBee III use a generic FTDI chip, only the strings
+		// tell them apart. There is no platform for the ConBee III, it is left to the firmware.
+		manufacturer := strings.ToLower(readSysfs(usbDevice, "manufacturer"))
+		if !strings.Contains(manufacturer, "dresden") && !strings.HasPrefix(adapter.Model, "ConBee") {
+			return Adapter{}, false
+		}
+		switch {
+		case adapter.Model == "":
+			adapter.Model = "ConBee"
+			adapter.Platform = Conbee
+		case !strings.HasPrefix(adapter.Model, "ConBee III"):
+			adapter.Platform = Conbee
+		}
+	default:
+		return Adapter{}, false
+	}
+	return adapter, true
+}
+
+func readByID(root string) map[string]string {
+	res := make(map[string]string)
+	dir := filepath.Join(root, "dev/serial/by-id")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return res
+	}
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		res[filepath.Base(target)] = "/dev/serial/by-id/" + entry.Name()
+	}
+	return res
+}
+
+// raspbeeUARTs returns the path to report by tty name of the uarts a RaspBee may be
+// attached to, none if this is not a Raspberry Pi.
+func raspbeeUARTs(root string) map[string]string {
+	if !isRaspberryPi(root) {
+		return nil
+	}
+	// serial0 links to the uart on the gpio header, whichever it is on this model.
+	if target, err := os.Readlink(filepath.Join(root, "dev/serial0")); err == nil {
+		return map[string]string{filepath.Base(target): "/dev/serial0"}
+	}
+	return map[string]string{
+		"ttyAMA0": "/dev/ttyAMA0",
+		"ttyS0":   "/dev/ttyS0",
+	}
+}
+
+func isRaspberryPi(root string) bool {
+	return strings.HasPrefix(readSysfs(root, "proc/device-tree/model"), "Raspberry Pi")
+}
+
+func readSysfs(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(strings.TrimSpace(string(data)), "\x00")
+}
@@ -0,0 +1,110 @@
+package serial
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string, links map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range links {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"sys/devices/usb1/1-1/idVendor":                            "1cf1\n",
+		"sys/devices/usb1/1-1/idProduct":                           "0030\n",
+		"sys/devices/usb1/1-1/serial":                              "DE2132514\n",
+		"sys/devices/usb1/1-1/product":                             "ConBee II\n",
+		"sys/devices/usb1/1-1/1-1:1.0/tty/ttyACM0/dev":             "166:0\n",
+		"sys/devices/usb1/1-2/idVendor":                            "0403\n",
+		"sys/devices/usb1/1-2/idProduct":                           "6015\n",
+		"sys/devices/usb1/1-2/serial":                              "DJ00QBWE\n",
+		"sys/devices/usb1/1-2/manufacturer":                        "FTDI\n",
+		"sys/devices/usb1/1-2/product":                             "FT230X Basic UART\n",
+		"sys/devices/usb1/1-2/1-2:1.0/ttyUSB0/tty/ttyUSB0/dev":     "188:0\n",
+		"sys/devices/platform/serial8250/tty/ttyS0/dev":            "4:64\n",
+		"proc/device-tree/model":                                   "Raspberry Pi 4 Model B Rev 1.4\x00",
+		"sys/devices/platform/soc/fe201000.serial/tty/ttyAMA0/dev": "204:64\n",
+	}, map[string]string{
+		"sys/class/tty/ttyACM0":                                   "../../devices/usb1/1-1/1-1:1.0/tty/ttyACM0",
+		"sys/devices/usb1/1-1/1-1:1.0/tty/ttyACM0/device":         "../../../1-1:1.0",
+		"sys/class/tty/ttyUSB0":                                   "../../devices/usb1/1-2/1-2:1.0/ttyUSB0/tty/ttyUSB0",
+		"sys/devices/usb1/1-2/1-2:1.0/ttyUSB0/tty/ttyUSB0/device": "../../../ttyUSB0",
+		"sys/class/tty/ttyS0":                                     "../../devices/platform/serial8250/tty/ttyS0",
+		"sys/class/tty/ttyAMA0":                                   "../../devices/platform/soc/fe201000.serial/tty/ttyAMA0",
+		"dev/serial/by-id/usb-dresden_elektronik_ingenieurtechnik_GmbH_ConBee_II_DE2132514-if00": "../../ttyACM0",
+	})
+
+	adapters, err := discover(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(adapters) != 3 {
+		t.Fatal("expected 3 adapters, got", adapters)
+	}
+	conbee := adapters[0]
+	if conbee.Path != "/dev/ttyACM0" || conbee.Serial != "DE2132514" || conbee.Platform != Conbee2 ||
+		conbee.ByID != "/dev/serial/by-id/usb-dresden_elektronik_ingenieurtechnik_GmbH_ConBee_II_DE2132514-if00" {
+		t.Fatal("unexpected adapter", conbee)
+	}
+	// Without serial0 both uarts are candidates, the platform is up to the firmware.
+	for i, path := range []string{"/dev/ttyAMA0", "/dev/ttyS0"} {
+		if raspbee := adapters[i+1]; raspbee.Path != path || raspbee.Model != "RaspBee" || raspbee.Platform != 0 {
+			t.Fatal("unexpected adapter", raspbee)
+		}
+	}
+
+	writeTree(t, root, nil, map[string]string{"dev/serial0": "ttyS0"})
+	adapters, err = discover(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(adapters) != 2 {
+		t.Fatal("expected 2 adapters, got", adapters)
+	}
+	if raspbee := adapters[0]; raspbee.Path != "/dev/serial0" || raspbee.Model != "RaspBee" {
+		t.Fatal("unexpected adapter", raspbee)
+	}
+}
+
+func TestDiscoverConbee3(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"sys/devices/usb1/1-3/idVendor":                        "0403\n",
+		"sys/devices/usb1/1-3/idProduct":                       "6015\n",
+		"sys/devices/usb1/1-3/manufacturer":                    "dresden elektronik\n",
+		"sys/devices/usb1/1-3/product":                         "ConBee III\n",
+		"sys/devices/usb1/1-3/1-3:1.0/ttyUSB0/tty/ttyUSB0/dev": "188:0\n",
+	}, map[string]string{
+		"sys/class/tty/ttyUSB0":                                   "../../devices/usb1/1-3/1-3:1.0/ttyUSB0/tty/ttyUSB0",
+		"sys/devices/usb1/1-3/1-3:1.0/ttyUSB0/tty/ttyUSB0/device": "../../../ttyUSB0",
+	})
+	adapters, err := discover(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The ConBee III shares the FTDI ids of the first ConBee, it must not be reported as one.
+	if len(adapters) != 1 || adapters[0].Model != "ConBee III" || adapters[0].Platform != 0 {
+		t.Fatal("unexpected adapters", adapters)
+	}
+}
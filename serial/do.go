@@ -0,0 +1,58 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"github.com/daedaluz/goconbee/serial/frame"
+)
+
+// Request is a command defined outside the package, see Do. Encode returns the frame
+// to write, e.g. frame.NewFrame(req.CommandID(), seqNumber, payload).
+type Request interface {
+	CommandID
+	Encode(seqNumber uint8) frame.Frame
+}
+
+// Response decodes the answer to a Request. Decode is only called for frames with the
+// command and sequence number of the request and frame.StatusSuccess, other statuses
+// are returned as a StatusError.
+type Response interface {
+	CommandID
+	Decode(f frame.Frame) error
+}
+
+type customRequest struct {
+	Request
+}
+
+func (r customRequest) encode(seqNumber uint8) frame.Frame {
+	return r.Encode(seqNumber)
+}
+
+type customResponse struct {
+	Response
+}
+
+func (r customResponse) decode(f frame.Frame) error {
+	err := r.Decode(f)
+	var decodeErr *DecodeError
+	if err != nil && !errors.As(err, &decodeErr) {
+		return newDecodeError(f, err)
+	}
+	return err
+}
+
+// Do sends req and decodes the answer into a new Resp, queued, timed out and retried
+// like the built-in commands:
+//
+//	res, err := serial.Do[BootloaderResponse](ctx, port, &BootloaderRequest{})
+func Do[Resp any, PResp interface {
+	*Resp
+	Response
+}](ctx context.Context, p *Port, req Request) (*Resp, error) {
+	res := PResp(new(Resp))
+	if err := p.execute(ctx, customRequest{req}, customResponse{res}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,57 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"testing"
+)
+
+type bootloaderRequest struct{}
+
+func (r *bootloaderRequest) CommandID() frame.Command {
+	return frame.CmdUpdateBootloader
+}
+
+func (r *bootloaderRequest) Encode(seqNumber uint8) frame.Frame {
+	return frame.NewFrame(frame.CmdUpdateBootloader, seqNumber, []byte{0x00})
+}
+
+type bootloaderResponse struct {
+	Version uint8
+}
+
+func (r *bootloaderResponse) CommandID() frame.Command {
+	return frame.CmdUpdateBootloader
+}
+
+func (r *bootloaderResponse) Decode(f frame.Frame) error {
+	if len(f.Data()) < 1 {
+		return errors.New("missing version")
+	}
+	r.Version = f.Data()[0]
+	return nil
+}
+
+func TestDo(t *testing.T) {
+	payload := []byte{0x05}
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+		if f.CommandID() != frame.CmdUpdateBootloader {
+			return nil
+		}
+		return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusSuccess, payload)}
+	})
+	res, err := Do[bootloaderResponse](context.Background(), port, &bootloaderRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Version != 5 {
+		t.Fatal("unexpected version", res.Version)
+	}
+	payload = nil
+	_, err = Do[bootloaderResponse](context.Background(), port, &bootloaderRequest{})
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Command != frame.CmdUpdateBootloader {
+		t.Fatal("expected a decode error, got", err)
+	}
+}
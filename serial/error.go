@@ -1,5 +1,10 @@
 package serial
 
+import (
+	"fmt"
+	"github.com/daedaluz/goconbee/serial/frame"
+)
+
 type Error struct {
 	str string
 	e   error
@@ -12,3 +17,62 @@ func (e Error) Error() string {
 func (e Error) Unwrap() error {
 	return e.e
 }
+
+var ErrClosed = Error{str: "port closed"}
+
+var ErrTimeout = Error{str: "command timeout"}
+
+// ErrDropped is returned for a command whose request was dropped by an Interceptor.
+var ErrDropped = Error{str: "request dropped by interceptor"}
+
+// ErrQueueFull is returned instead of waiting for room in the queue, see WithNonBlocking.
+var ErrQueueFull = Error{str: "command queue full"}
+
+// StatusError is returned when the firmware answers a command with a status other than
+// frame.StatusSuccess. It unwraps to the status, so errors.Is(err, frame.StatusBusy) works.
+type StatusError struct {
+	Command frame.Command
+	Status  frame.Status
+	// Frame is the response as read from the stick.
+	Frame frame.Frame
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Command, e.Status)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Status
+}
+
+// Is reports frame.StatusUnsupported as ErrUnsupported.
+func (e *StatusError) Is(target error) bool {
+	return target == ErrUnsupported && e.Status == frame.StatusUnsupported
+}
+
+// DecodeError is returned when a frame from the stick can not be decoded.
+type DecodeError struct {
+	Command frame.Command
+	Frame   frame.Frame
+	Err     error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode %s: %s", e.Command, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func newDecodeError(f frame.Frame, err error) *DecodeError {
+	return &DecodeError{Command: f.CommandID(), Frame: f, Err: err}
+}
+
+// checkLength returns a DecodeError if the payload of f is shorter than n bytes.
+func checkLength(f frame.Frame, n int) error {
+	if len(f.Data()) < n {
+		return newDecodeError(f, fmt.Errorf("payload of %d bytes, expected at least %d", len(f.Data()), n))
+	}
+	return nil
+}
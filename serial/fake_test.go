@@ -0,0 +1,100 @@
+package serial
+
+import (
+	"github.com/daedaluz/goconbee/serial/frame"
+	"github.com/daedaluz/goslip"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeStick answers frames written by a Port with whatever respond returns.
+type fakeStick struct {
+	conn    net.Conn
+	rw      slip.ReadWriter
+	respond func(f frame.Frame) []frame.Frame
+}
+
+// newFakeStick opens a port on a fakeStick. Reads time out after options.ReadTimeout, like
+// on a tty, nil options read without a timeout. nil handlers ignore unsolicited frames and
+// disconnects.
+func newFakeStick(t *testing.T, options *Options, handlers *Handlers, respond func(f frame.Frame) []frame.Frame) (*Port, *fakeStick) {
+	t.Helper()
+	local, remote := net.Pipe()
+	stick := serveFake(remote, respond)
+	if handlers == nil {
+		handlers = &Handlers{
+			UnsolicitedHandler: func(p *Port, msg CommandID) {},
+			DisconnectHandler:  func(p *Port) {},
+		}
+	}
+	var conn net.Conn = local
+	if options != nil && options.ReadTimeout > 0 {
+		conn = timeoutConn{Conn: local, timeout: options.ReadTimeout}
+	}
+	port, err := OpenTransportWithOptions(conn, handlers, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		port.Close()
+		remote.Close()
+	})
+	return port, stick
+}
+
+func serveFake(conn net.Conn, respond func(f frame.Frame) []frame.Frame) *fakeStick {
+	stick := &fakeStick{
+		conn:    conn,
+		rw:      slip.NewReadWriter(conn),
+		respond: respond,
+	}
+	go stick.serve()
+	return stick
+}
+
+func (s *fakeStick) serve() {
+	for {
+		data, err := s.rw.ReadPacket()
+		if err != nil {
+			return
+		}
+		if len(data) == 0 {
+			continue
+		}
+		for _, res := range s.respond(data) {
+			if err := s.rw.WritePacket(res); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *fakeStick) send(f frame.Frame) error {
+	return s.rw.WritePacket(f)
+}
+
+// timeoutConn wakes up the reader regularly, like a tty with a read timeout.
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c timeoutConn) Read(b []byte) (int, error) {
+	c.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func versionResponder(f frame.Frame) []frame.Frame {
+	if f.CommandID() != frame.CmdVersion {
+		return nil
+	}
+	return []frame.Frame{statusFrame(frame.CmdVersion, f.SeqNumber(), frame.StatusSuccess, []byte{0x00, byte(Conbee2), 0x72, 0x26})}
+}
+
+func statusFrame(cmd frame.Command, seq uint8, status frame.Status, payload []byte) frame.Frame {
+	f := frame.NewFrame(cmd, seq, payload)
+	f[2] = byte(status)
+	f.UpdateCRC()
+	return f
+}
@@ -0,0 +1,126 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"strings"
+)
+
+var ErrUnsupported = Error{str: "not supported by firmware"}
+
+type Capabilities uint32
+
+const (
+	// The firmware reboots unless ParamWatchdogTTL is refreshed.
+	CapWatchdog = Capabilities(1 << iota)
+	// ReadReceivedData takes ReadDataFlag to choose the addresses and link info in ApsData.
+	CapDataIndicationFlags
+	// SendData takes a source route.
+	CapSourceRouting
+)
+
+var capabilityNames = []string{"watchdog", "data indication flags", "source routing"}
+
+func (c Capabilities) Has(x Capabilities) bool {
+	return c&x == x
+}
+
+func (c Capabilities) String() string {
+	var names []string
+	for i, name := range capabilityNames {
+		if c.Has(Capabilities(1 << i)) {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// Protocol version a parameter was introduced in, parameters not listed are always available.
+var parameterSince = map[ParameterID]uint16{
+	ParamWatchdogTTL: 0x0108,
+}
+
+var capabilitySince = map[Capabilities]uint16{
+	CapWatchdog:            parameterSince[ParamWatchdogTTL],
+	CapDataIndicationFlags: 0x0108,
+	CapSourceRouting:       0x010b,
+}
+
+// Handshake reads the firmware and protocol version of the stick. After a handshake,
+// reading or writing parameters and using the Capabilities the firmware does not support
+// fails with ErrUnsupported. Commands are not checked, unsupported ones are answered with
+// frame.StatusUnsupported, which matches ErrUnsupported as well.
+func (p *Port) Handshake() error {
+	p.setState(StateHandshaking)
+	version, err := p.ReadFirmwareVersion()
+	if err != nil {
+		p.setState(StateDegraded, StateHandshaking)
+		return err
+	}
+	protocol := uint16(0)
+	if err := p.ReadParameter(ParamProtocolVersion, &protocol); err != nil {
+		// Firmwares that predate the parameter don't support it.
+		if !errors.Is(err, frame.StatusUnsupported) {
+			p.setState(StateDegraded, StateHandshaking)
+			return err
+		}
+		protocol = 0
+	}
+	capabilities := Capabilities(0)
+	for capability, since := range capabilitySince {
+		if protocol >= since {
+			capabilities |= capability
+		}
+	}
+	p.featureLock.Lock()
+	p.firmware = version
+	p.protocol = protocol
+	p.capabilities = capabilities
+	p.featureLock.Unlock()
+	p.setState(StateReady, StateHandshaking)
+	return nil
+}
+
+// Firmware returns the firmware version found by Handshake, or nil.
+func (p *Port) Firmware() *FirmwareVersion {
+	p.featureLock.Lock()
+	defer p.featureLock.Unlock()
+	return p.firmware
+}
+
+// ProtocolVersion returns the protocol version found by Handshake.
+func (p *Port) ProtocolVersion() uint16 {
+	p.featureLock.Lock()
+	defer p.featureLock.Unlock()
+	return p.protocol
+}
+
+func (p *Port) Capabilities() Capabilities {
+	p.featureLock.Lock()
+	defer p.featureLock.Unlock()
+	return p.capabilities
+}
+
+func (p *Port) checkSince(since uint16, what fmt.Stringer) error {
+	p.featureLock.Lock()
+	defer p.featureLock.Unlock()
+	if p.firmware == nil || p.protocol >= since {
+		return nil
+	}
+	return Error{
+		str: fmt.Sprintf("%s requires protocol version 0x%.4x, firmware has 0x%.4x", what, since, p.protocol),
+		e:   ErrUnsupported,
+	}
+}
+
+func (p *Port) checkCapability(c Capabilities) error {
+	return p.checkSince(capabilitySince[c], c)
+}
+
+func (p *Port) checkParameter(param ParameterID) error {
+	if since, ok := parameterSince[param]; ok {
+		return p.checkSince(since, param)
+	}
+	return nil
+}
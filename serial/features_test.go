@@ -0,0 +1,84 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHandshakeGating(t *testing.T) {
+	writes := atomic.Int32{}
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+		switch f.CommandID() {
+		case frame.CmdVersion:
+			return versionResponder(f)
+		case frame.CmdReadParameter:
+			return []frame.Frame{statusFrame(frame.CmdReadParameter, f.SeqNumber(), frame.StatusSuccess, []byte{3, 0, byte(ParamProtocolVersion), 0x07, 0x01})}
+		case frame.CmdWriteParameter:
+			writes.Add(1)
+			return []frame.Frame{statusFrame(frame.CmdWriteParameter, f.SeqNumber(), frame.StatusSuccess, []byte{1, 0, f.Data()[2]})}
+		default:
+			writes.Add(1)
+		}
+		return nil
+	})
+	if err := port.WriteParameter(ParamWatchdogTTL, uint32(60)); err != nil {
+		t.Fatal("expected writes to pass before the handshake, got", err)
+	}
+	if err := port.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if port.ProtocolVersion() != 0x0107 || port.Capabilities().Has(CapWatchdog) {
+		t.Fatal("unexpected handshake result", port.ProtocolVersion(), port.Capabilities())
+	}
+	if err := port.WriteParameter(ParamWatchdogTTL, uint32(60)); !errors.Is(err, ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported, got", err)
+	}
+	if _, err := port.ReadReceivedData(FlagLastHop); !errors.Is(err, ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported for data indication flags, got", err)
+	}
+	if _, err := port.ReadReceivedDataAsync(context.Background(), FlagLastHop).Wait(context.Background()); !errors.Is(err, ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported for async data indication flags, got", err)
+	}
+	if _, err := port.StartDataPump(FlagLastHop, 1); !errors.Is(err, ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported for a data pump with flags, got", err)
+	}
+	dst := Address{Mode: AddressNWK, Short: 0x1234}
+	if _, err := port.SendData(1, dst, 0x0104, 6, 1, []byte{1}, 0, 0, 0x1111); !errors.Is(err, ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported for source routing, got", err)
+	}
+	if _, err := port.SendDataAsync(context.Background(), 1, dst, 0x0104, 6, 1, []byte{1}, 0, 0, 0x1111).Wait(context.Background()); !errors.Is(err, ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported for async source routing, got", err)
+	}
+	if writes.Load() != 1 {
+		t.Fatal("unsupported request was sent to the stick")
+	}
+}
+
+func TestHandshakeProtocolVersion(t *testing.T) {
+	for _, test := range []struct {
+		status   frame.Status
+		protocol uint16
+		state    State
+		fails    bool
+	}{
+		{status: frame.StatusUnsupported, protocol: 0, state: StateReady},
+		{status: frame.StatusFailure, state: StateDegraded, fails: true},
+	} {
+		port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+			switch f.CommandID() {
+			case frame.CmdVersion:
+				return versionResponder(f)
+			case frame.CmdReadParameter:
+				return []frame.Frame{statusFrame(frame.CmdReadParameter, f.SeqNumber(), test.status, []byte{1, 0, byte(ParamProtocolVersion)})}
+			}
+			return nil
+		})
+		err := port.Handshake()
+		if test.fails != (err != nil) || !test.fails && port.ProtocolVersion() != test.protocol || port.State() != test.state {
+			t.Fatal("unexpected handshake result for", test.status, err, port.ProtocolVersion(), port.State())
+		}
+	}
+}
@@ -43,12 +43,22 @@ func (f Frame) Status() Status {
 	return Status(f[2])
 }
 
+// Data returns the payload of the frame, or nil if the frame is too short to have one.
 func (f Frame) Data() []byte {
+	if len(f) < 7 {
+		return nil
+	}
 	idxStart := 5
 	idxEnd := len(f) - 2
 	return f[idxStart:idxEnd]
 }
 
+// UpdateCRC recalculates the crc after the frame has been changed.
+func (f Frame) UpdateCRC() {
+	crc := crc16(f[0 : len(f)-2])
+	binary.LittleEndian.PutUint16(f[len(f)-2:], crc)
+}
+
 func NewFrame(cmd Command, seq uint8, payload []byte) Frame {
 	x := bytes.NewBuffer(make([]byte, 0, 5+len(payload)+2))
 	x.WriteByte(byte(cmd)) // Command
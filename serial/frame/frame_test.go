@@ -16,3 +16,15 @@ func TestCRC(t *testing.T) {
 		t.Fatal("CRC failed, expected:", f.getCRC(), "got", f)
 	}
 }
+
+func TestUpdateCRC(t *testing.T) {
+	f := NewFrame(CmdVersion, 1, []byte{0, 0, 0, 0})
+	f[1] = 2
+	if f.CheckCRC() {
+		t.Fatal("CRC passed on changed frame")
+	}
+	f.UpdateCRC()
+	if !f.CheckCRC() {
+		t.Fatal("CRC failed after update")
+	}
+}
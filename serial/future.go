@@ -0,0 +1,331 @@
+package serial
+
+import (
+	"context"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"sync"
+	"time"
+)
+
+// Future is the result of a command sent with one of the Async methods. Many commands
+// can be sent at once this way, without a goroutine waiting for each of them. The Async
+// methods never block, the Future fails with ErrQueueFull if the queue is full. Once ctx
+// is done the command is canceled as with Cancel and the Future fails with ctx.Err().
+type Future[T any] struct {
+	done   chan struct{}
+	once   sync.Once
+	result T
+	err    error
+	cancel func(err error)
+}
+
+func newFuture[T any]() *Future[T] {
+	return &Future[T]{done: make(chan struct{})}
+}
+
+// Done is closed once the result is available.
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result blocks until the command is done and returns its result.
+func (f *Future[T]) Result() (T, error) {
+	<-f.done
+	return f.result, f.err
+}
+
+// Wait is Result that returns ctx.Err() if ctx is done first, the command carries on.
+func (f *Future[T]) Wait(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Cancel fails the command with context.Canceled unless it is already done. A queued
+// command is removed, the response to one in flight is ignored.
+func (f *Future[T]) Cancel() {
+	if f.cancel != nil {
+		f.cancel(context.Canceled)
+	}
+}
+
+func (f *Future[T]) complete(result T, err error) {
+	f.once.Do(func() {
+		f.result = result
+		f.err = err
+		close(f.done)
+	})
+}
+
+// asyncCall runs a request like execute does, driven by the completion of its commands
+// instead of a waiting goroutine.
+type asyncCall struct {
+	p       *Port
+	ctx     context.Context
+	req     request
+	res     response
+	retries *retries
+	finish  func(err error)
+
+	lock    sync.Mutex
+	cmd     *requestResponseCommand
+	timer   *time.Timer
+	stopped bool
+}
+
+// executeAsync sends req and completes the returned future with result once the response
+// is decoded into res.
+func executeAsync[T any](ctx context.Context, p *Port, req request, res response, result func() (T, error)) *Future[T] {
+	f := newFuture[T]()
+	a := &asyncCall{
+		p:       p,
+		ctx:     ctx,
+		req:     req,
+		res:     res,
+		retries: p.newRetries(ctx, req.CommandID()),
+		finish: func(err error) {
+			var x T
+			if err == nil {
+				x, err = result()
+			}
+			f.complete(x, err)
+		},
+	}
+	f.cancel = a.cancel
+	if done := ctx.Done(); done != nil {
+		go func() {
+			select {
+			case <-done:
+				a.cancel(ctx.Err())
+			case <-f.done:
+			}
+		}()
+	}
+	a.attempt()
+	return f
+}
+
+func (a *asyncCall) attempt() {
+	cmd := newRequestResponseCommand(a.req, a.res, a.p.commandTimeout(a.ctx))
+	a.lock.Lock()
+	if a.stopped {
+		a.lock.Unlock()
+		return
+	}
+	a.cmd = cmd
+	a.lock.Unlock()
+	cmd.onComplete(func() { a.attemptDone(cmd) })
+	if err := a.ctx.Err(); err != nil {
+		cmd.complete(err)
+		return
+	}
+	a.p.submit(WithNonBlocking(a.ctx), a.p.priority(a.ctx, a.req.CommandID()), cmd)
+}
+
+func (a *asyncCall) attemptDone(cmd *requestResponseCommand) {
+	err, _ := cmd.wait().(error)
+	a.lock.Lock()
+	if a.stopped {
+		a.lock.Unlock()
+		return
+	}
+	if backoff, retry := a.retries.next(err); retry {
+		a.timer = time.AfterFunc(backoff, a.attempt)
+		a.lock.Unlock()
+		return
+	}
+	a.stopped = true
+	a.lock.Unlock()
+	a.finish(err)
+}
+
+func (a *asyncCall) cancel(err error) {
+	a.lock.Lock()
+	if a.stopped {
+		a.lock.Unlock()
+		return
+	}
+	a.stopped = true
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	cmd := a.cmd
+	a.lock.Unlock()
+	if cmd != nil {
+		cmd.complete(err)
+		a.p.queue.remove(cmd)
+	}
+	a.finish(err)
+}
+
+// DoAsync is Do returning a Future.
+func DoAsync[Resp any, PResp interface {
+	*Resp
+	Response
+}](ctx context.Context, p *Port, req Request) *Future[*Resp] {
+	res := PResp(new(Resp))
+	return executeAsync(ctx, p, customRequest{req}, customResponse{res}, func() (*Resp, error) {
+		return res, nil
+	})
+}
+
+// failedFuture returns a Future that is done with err.
+func failedFuture[T any](err error) *Future[T] {
+	f := newFuture[T]()
+	var zero T
+	f.complete(zero, err)
+	return f
+}
+
+// noResult is the result of the Async methods of commands that return nothing but an error.
+func noResult() (struct{}, error) {
+	return struct{}{}, nil
+}
+
+// The Async methods below queue the command and return without waiting for the response,
+// see Future.
+
+// ReadFirmwareVersionAsync is ReadFirmwareVersionContext returning a Future.
+func (p *Port) ReadFirmwareVersionAsync(ctx context.Context) *Future[*FirmwareVersion] {
+	version := &FirmwareVersion{}
+	return executeAsync(ctx, p, &readFirmwareVersionRequest{}, version, func() (*FirmwareVersion, error) {
+		return version, nil
+	})
+}
+
+// ReadParameterRawAsync is ReadParameterRawContext returning a Future.
+func (p *Port) ReadParameterRawAsync(ctx context.Context, param ParameterID) *Future[[]byte] {
+	if err := p.checkParameter(param); err != nil {
+		return failedFuture[[]byte](err)
+	}
+	paramResp := &ReadParameterResponse{}
+	return executeAsync(ctx, p, &readParameterRequest{parameterId: param}, paramResp, func() ([]byte, error) {
+		return paramResp.value, nil
+	})
+}
+
+// ReadParameterAsync is ReadParameterContext returning a Future, out is set once the future
+// is done without an error.
+func (p *Port) ReadParameterAsync(ctx context.Context, param ParameterID, out any, args ...any) *Future[struct{}] {
+	if err := p.checkParameter(param); err != nil {
+		return failedFuture[struct{}](err)
+	}
+	paramResp := &ReadParameterResponse{}
+	return executeAsync(ctx, p, &readParameterRequest{parameterId: param, args: encodeParameter(nil, args...)}, paramResp, func() (struct{}, error) {
+		decodeParameter(paramResp.value, out)
+		return struct{}{}, nil
+	})
+}
+
+// WriteParameterRawAsync is WriteParameterRawContext returning a Future.
+func (p *Port) WriteParameterRawAsync(ctx context.Context, param ParameterID, value []byte) *Future[struct{}] {
+	if err := p.checkParameter(param); err != nil {
+		return failedFuture[struct{}](err)
+	}
+	return executeAsync(ctx, p, &writeParameterRequest{
+		parameterID: param,
+		value:       value,
+	}, &WriteParameterResponse{}, noResult)
+}
+
+// WriteParameterAsync is WriteParameterContext returning a Future.
+func (p *Port) WriteParameterAsync(ctx context.Context, param ParameterID, value any, args ...any) *Future[struct{}] {
+	if err := p.checkParameter(param); err != nil {
+		return failedFuture[struct{}](err)
+	}
+	return executeAsync(ctx, p, &writeParameterRequest{
+		parameterID: param,
+		value:       encodeParameter(value, args...),
+	}, &WriteParameterResponse{}, noResult)
+}
+
+// GetDeviceStateAsync is GetDeviceStateContext returning a Future.
+func (p *Port) GetDeviceStateAsync(ctx context.Context) *Future[*DeviceState] {
+	stateResp := &DeviceState{}
+	return executeAsync(ctx, p, &deviceStateRequest{}, stateResp, func() (*DeviceState, error) {
+		return stateResp, nil
+	})
+}
+
+// ChangeNetworkStateAsync is ChangeNetworkStateContext returning a Future.
+func (p *Port) ChangeNetworkStateAsync(ctx context.Context, state NetworkState) *Future[struct{}] {
+	return executeAsync(ctx, p, &changeNetworkStateRequest{NetworkState: state}, &ChangeNetworkStateResponse{}, noResult)
+}
+
+// ReadReceivedDataAsync is ReadReceivedDataContext returning a Future.
+func (p *Port) ReadReceivedDataAsync(ctx context.Context, flags ReadDataFlag) *Future[*ApsData] {
+	if flags != 0 {
+		if err := p.checkCapability(CapDataIndicationFlags); err != nil {
+			return failedFuture[*ApsData](err)
+		}
+	}
+	resp := &ApsData{}
+	return executeAsync(ctx, p, &apsReadDataRequest{flags: flags}, resp, func() (*ApsData, error) {
+		return resp, nil
+	})
+}
+
+// SendDataAsync is SendDataContext returning a Future.
+func (p *Port) SendDataAsync(ctx context.Context, reqID uint8, dstAddr Address, profileID, clusterID uint16, srcEP uint8, data []byte, opts TXOptions, radius uint8, srcRoute ...uint16) *Future[*SendDataResponse] {
+	resp := &SendDataResponse{}
+	req := &SendDataRequest{
+		RequestID:  reqID,
+		DstAddress: dstAddr,
+		ProfileID:  profileID,
+		ClusterID:  clusterID,
+		SrcEP:      srcEP,
+		Data:       data,
+		Options:    opts,
+		Radius:     radius,
+	}
+	if len(srcRoute) > 0 {
+		if err := p.checkCapability(CapSourceRouting); err != nil {
+			return failedFuture[*SendDataResponse](err)
+		}
+		req.Flags |= sendDataFlagSourceRouting
+		req.Relay = srcRoute
+	}
+	return executeAsync(ctx, p, req, resp, func() (*SendDataResponse, error) {
+		return resp, nil
+	})
+}
+
+// QuerySendDataAsync is QuerySendDataContext returning a Future.
+func (p *Port) QuerySendDataAsync(ctx context.Context) *Future[*QuerySendDataResponse] {
+	resp := &QuerySendDataResponse{}
+	return executeAsync(ctx, p, &querySendDataRequest{}, resp, func() (*QuerySendDataResponse, error) {
+		return resp, nil
+	})
+}
+
+// AddNeighborAsync is AddNeighborContext returning a Future.
+func (p *Port) AddNeighborAsync(ctx context.Context, nwk uint16, IEEEAddr uint64, macCapabilities MacCapabilities) *Future[struct{}] {
+	return executeAsync(ctx, p, &updateNeighborRequest{
+		Action:          actionAdd,
+		NWK:             nwk,
+		IEEEAddr:        IEEEAddr,
+		MacCapabilities: macCapabilities,
+	}, &UpdateNeighborResponse{}, noResult)
+}
+
+// RemoveNeighborAsync is RemoveNeighborContext returning a Future.
+func (p *Port) RemoveNeighborAsync(ctx context.Context, nwk uint16, IEEEAddr uint64, macCapabilities MacCapabilities) *Future[struct{}] {
+	return executeAsync(ctx, p, &updateNeighborRequest{
+		Action:          actionRemove,
+		NWK:             nwk,
+		IEEEAddr:        IEEEAddr,
+		MacCapabilities: macCapabilities,
+	}, &UpdateNeighborResponse{}, noResult)
+}
+
+// SendRawAsync is SendRaw returning a Future. The response to a command that fails with a status other than frame.StatusSuccess is in the StatusError.
+func (p *Port) SendRawAsync(ctx context.Context, cmd frame.Command, payload []byte) *Future[frame.Frame] {
+	res := &rawResponse{cmd: cmd}
+	return executeAsync(withoutRetries(ctx), p, &rawRequest{cmd: cmd, payload: payload}, res, func() (frame.Frame, error) {
+		return res.frame, nil
+	})
+}
@@ -0,0 +1,165 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFuture(t *testing.T) {
+	// The Async methods don't wait for room in the queue, it must hold every request.
+	port, _ := newFakeStick(t, NewOptions().SetQueueSize(200), nil, func(f frame.Frame) []frame.Frame {
+		if f.CommandID() != frame.CmdAPSDataRequest {
+			return nil
+		}
+		return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusSuccess, []byte{0, 0, 0x22, f.Data()[2]})}
+	})
+	port.SetWindow(4)
+	futures := make([]*Future[*SendDataResponse], 200)
+	for i := range futures {
+		futures[i] = port.SendDataAsync(context.Background(), uint8(i), Address{Mode: AddressNWK, Short: uint16(i), Endpoint: 1}, 0x0104, 0x0006, 1, []byte{0x01}, 0, 0)
+	}
+	for i, f := range futures {
+		res, err := f.Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.RequestID != uint8(i) {
+			t.Fatal("expected request", i, "got", res.RequestID)
+		}
+	}
+}
+
+func TestFutureRetry(t *testing.T) {
+	var states atomic.Int32
+	port, _ := newFakeStick(t, NewOptions().
+		SetReadTimeout(time.Millisecond*50).
+		SetCommandTimeout(time.Millisecond*50).
+		SetBusyRetry(RetryPolicy{Attempts: 2, Backoff: time.Millisecond}).
+		SetRetry(RetryPolicy{Attempts: 2, Backoff: time.Millisecond}), nil, func(f frame.Frame) []frame.Frame {
+		switch states.Add(1) {
+		case 1:
+			return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusBusy, nil)}
+		case 2:
+			return nil
+		}
+		return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusSuccess, []byte{0x02})}
+	})
+	state, err := port.GetDeviceStateAsync(context.Background()).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.NetworkState != NetConnected || states.Load() != 3 {
+		t.Fatal("unexpected", state, states.Load())
+	}
+}
+
+func TestFutureCancel(t *testing.T) {
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame { return nil })
+	f := port.GetDeviceStateAsync(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := f.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatal("expected", context.DeadlineExceeded, "got", err)
+	}
+	f.Cancel()
+	if _, err := f.Result(); !errors.Is(err, context.Canceled) {
+		t.Fatal("expected", context.Canceled, "got", err)
+	}
+	if stats := port.QueueStats(); stats.InFlight != 0 || stats.Queued != 0 {
+		t.Fatal("cancelled command not released", stats)
+	}
+
+	port.Close()
+	if _, err := port.ReadFirmwareVersionAsync(context.Background()).Result(); !errors.Is(err, ErrClosed) {
+		t.Fatal("expected", ErrClosed, "got", err)
+	}
+}
+
+func TestFutureParameters(t *testing.T) {
+	written := make(chan []byte, 1)
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+		switch f.CommandID() {
+		case frame.CmdReadParameter:
+			return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusSuccess, []byte{10, 0, byte(ParamMACAddress), 1, 2, 3, 4, 5, 6, 7, 8})}
+		case frame.CmdWriteParameter:
+			written <- append([]byte(nil), f.Data()[3:]...)
+			return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusSuccess, []byte{1, 0, f.Data()[2]})}
+		}
+		return nil
+	})
+	mac := uint64(0)
+	if _, err := port.ReadParameterAsync(context.Background(), ParamMACAddress, &mac).Result(); err != nil {
+		t.Fatal(err)
+	}
+	if mac != 0x0807060504030201 {
+		t.Fatalf("unexpected mac %x", mac)
+	}
+	if _, err := port.WriteParameterAsync(context.Background(), ParamNWKPANID, uint16(0x1234)).Result(); err != nil {
+		t.Fatal(err)
+	}
+	if value := <-written; len(value) != 2 || value[0] != 0x34 || value[1] != 0x12 {
+		t.Fatalf("unexpected value %X", value)
+	}
+}
+
+func TestFutureQueueFull(t *testing.T) {
+	port, _ := newFakeStick(t, NewOptions().SetQueueSize(1), nil, func(f frame.Frame) []frame.Frame { return nil })
+
+	// One command in flight, one queued.
+	for i := 0; i < 2; i++ {
+		port.GetDeviceStateAsync(context.Background())
+		for deadline := time.Now().Add(time.Second); ; {
+			if stats := port.QueueStats(); stats.InFlight == 1 && stats.Queued == i {
+				break
+			} else if time.Now().After(deadline) {
+				t.Fatal("command not queued", stats)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	f := port.ChangeNetworkStateAsync(context.Background(), NetConnected)
+	select {
+	case <-f.Done():
+	default:
+		t.Fatal("future not done at once")
+	}
+	if _, err := f.Result(); err != ErrQueueFull {
+		t.Fatal("expected", ErrQueueFull, "got", err)
+	}
+}
+
+func TestFutureContext(t *testing.T) {
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame { return nil })
+	ctx, cancel := context.WithCancel(context.Background())
+	inFlight := port.GetDeviceStateAsync(ctx)
+	queued := port.ReadFirmwareVersionAsync(ctx)
+	for deadline := time.Now().Add(time.Second); ; {
+		if stats := port.QueueStats(); stats.InFlight == 1 && stats.Queued == 1 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("commands not sent", stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	for _, done := range []<-chan struct{}{inFlight.Done(), queued.Done()} {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("future not done after its context")
+		}
+	}
+	if _, err := inFlight.Result(); !errors.Is(err, context.Canceled) {
+		t.Fatal("expected", context.Canceled, "got", err)
+	}
+	if _, err := queued.Result(); !errors.Is(err, context.Canceled) {
+		t.Fatal("expected", context.Canceled, "got", err)
+	}
+	if stats := port.QueueStats(); stats.InFlight != 0 || stats.Queued != 0 {
+		t.Fatal("cancelled commands not released", stats)
+	}
+}
@@ -0,0 +1,78 @@
+package serial
+
+import (
+	"github.com/daedaluz/goconbee/serial/frame"
+	"time"
+)
+
+type Direction uint8
+
+const (
+	DirectionTX = Direction(iota)
+	DirectionRX
+)
+
+// FrameEvent is a frame on its way to or from the stick.
+type FrameEvent struct {
+	Time      time.Time
+	Direction Direction
+	Frame     frame.Frame
+	// CRCValid is the result of CheckCRC on Frame, it is checked again after every
+	// interceptor, which may have changed the frame.
+	CRCValid bool
+}
+
+// Interceptor is called for every frame written to and read from the stick, including
+// received frames that fail the CRC check. An interceptor may change ev.Frame, later
+// interceptors and the port see the changed frame. Returning false drops the frame, a
+// command whose request is dropped fails with ErrDropped right away.
+type Interceptor func(p *Port, ev *FrameEvent) bool
+
+type interceptorEntry struct {
+	fn Interceptor
+}
+
+// AddInterceptor registers i, interceptors run in the order they were added.
+// The returned function removes the interceptor again. Interceptors that must see
+// every frame, from the handshake on, are added with Options.AddInterceptor instead.
+func (p *Port) AddInterceptor(i Interceptor) (remove func()) {
+	entry := &interceptorEntry{fn: i}
+	p.interceptLock.Lock()
+	defer p.interceptLock.Unlock()
+	p.interceptors = append(p.interceptors[:len(p.interceptors):len(p.interceptors)], entry)
+	return func() {
+		p.interceptLock.Lock()
+		defer p.interceptLock.Unlock()
+		res := make([]*interceptorEntry, 0, len(p.interceptors))
+		for _, x := range p.interceptors {
+			if x != entry {
+				res = append(res, x)
+			}
+		}
+		p.interceptors = res
+	}
+}
+
+// intercept runs the interceptors on f, it returns the frame to use and false if it was dropped.
+func (p *Port) intercept(dir Direction, f frame.Frame) (frame.Frame, bool) {
+	p.interceptLock.RLock()
+	interceptors := p.interceptors
+	p.interceptLock.RUnlock()
+	if len(interceptors) == 0 {
+		return f, true
+	}
+	ev := &FrameEvent{
+		Time:      time.Now(),
+		Direction: dir,
+		Frame:     f,
+		CRCValid:  f.CheckCRC(),
+	}
+	for _, i := range interceptors {
+		if !i.fn(p, ev) {
+			return nil, false
+		}
+		// The frame may have been changed in place as well as replaced.
+		ev.CRCValid = ev.Frame.CheckCRC()
+	}
+	return ev.Frame, true
+}
@@ -0,0 +1,107 @@
+package serial
+
+import (
+	"errors"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInterceptor(t *testing.T) {
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+		corrupt := statusFrame(frame.CmdDeviceStateChanged, 0, frame.StatusSuccess, []byte{0x02})
+		corrupt[len(corrupt)-1]++
+		return append([]frame.Frame{corrupt}, versionResponder(f)...)
+	})
+	lock := sync.Mutex{}
+	var events []FrameEvent
+	remove := port.AddInterceptor(func(p *Port, ev *FrameEvent) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, *ev)
+		return true
+	})
+	if _, err := port.ReadFirmwareVersion(); err != nil {
+		t.Fatal(err)
+	}
+	remove()
+	if _, err := port.ReadFirmwareVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(events) != 3 {
+		t.Fatal("expected 3 frames, got", events)
+	}
+	if ev := events[0]; ev.Direction != DirectionTX || ev.Frame.CommandID() != frame.CmdVersion || !ev.CRCValid {
+		t.Fatal("unexpected tx event", ev)
+	}
+	if ev := events[1]; ev.Direction != DirectionRX || ev.CRCValid {
+		t.Fatal("expected corrupt rx frame, got", ev)
+	}
+	if ev := events[2]; ev.Direction != DirectionRX || ev.Frame.CommandID() != frame.CmdVersion || !ev.CRCValid {
+		t.Fatal("unexpected rx event", ev)
+	}
+}
+
+func TestInterceptorDrop(t *testing.T) {
+	port, _ := newFakeStick(t, nil, nil, versionResponder)
+	remove := port.AddInterceptor(func(p *Port, ev *FrameEvent) bool {
+		return ev.Direction != DirectionTX
+	})
+	start := time.Now()
+	if _, err := port.ReadFirmwareVersion(); !errors.Is(err, ErrDropped) {
+		t.Fatal("expected", ErrDropped, "got", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("dropped request waited for the timeout")
+	}
+	remove()
+	if _, err := port.ReadFirmwareVersion(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInterceptorOptions(t *testing.T) {
+	lock := sync.Mutex{}
+	var events []FrameEvent
+	options := NewOptions().AddInterceptor(func(p *Port, ev *FrameEvent) bool {
+		if ev.Direction == DirectionRX {
+			// Break the crc, so the next interceptor sees a corrupt frame.
+			ev.Frame = append(frame.Frame(nil), ev.Frame...)
+			ev.Frame[len(ev.Frame)-1]++
+		}
+		return true
+	}).AddInterceptor(func(p *Port, ev *FrameEvent) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, *ev)
+		if ev.Direction == DirectionRX {
+			ev.Frame[len(ev.Frame)-1]--
+		}
+		return true
+	})
+	options.Handshake = true
+	port, _ := newFakeStick(t, options, nil, func(f frame.Frame) []frame.Frame {
+		if f.CommandID() == frame.CmdReadParameter {
+			return []frame.Frame{statusFrame(frame.CmdReadParameter, f.SeqNumber(), frame.StatusSuccess, []byte{3, 0, byte(ParamProtocolVersion), 0x0b, 0x01})}
+		}
+		return versionResponder(f)
+	})
+	if port.ProtocolVersion() != 0x010b {
+		t.Fatal("handshake failed", port.ProtocolVersion())
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(events) != 4 || events[0].Frame.CommandID() != frame.CmdVersion {
+		t.Fatal("expected the handshake frames, got", events)
+	}
+	for _, ev := range events {
+		if ev.CRCValid != (ev.Direction == DirectionTX) {
+			t.Fatal("crc of a changed frame was not checked again", ev)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+// Package manager runs several sticks, and with them several Zigbee networks, in one process.
+//
+// A Manager owns the ports of its adapters, merges their unsolicited frames and state changes
+// into one stream of events tagged with the adapter name and routes data requests to the
+// adapter of the destination network.
+package manager
+
+//go:generate stringer -output=strings.go -type=EventKind
+
+import (
+	"errors"
+	"fmt"
+	"github.com/daedaluz/goconbee/serial"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClosed       = errors.New("manager closed")
+	ErrUnknown      = errors.New("unknown adapter")
+	ErrDuplicate    = errors.New("adapter already added")
+	ErrNoRoute      = errors.New("no adapter for network")
+	ErrAmbiguousPAN = errors.New("network served by more than one adapter")
+)
+
+type EventKind uint8
+
+const (
+	// EventUnsolicited carries a frame the stick sent on its own in Message.
+	EventUnsolicited = EventKind(iota)
+	// EventState is a state change of the port from Old to New.
+	EventState
+	EventDisconnected
+	EventReconnected
+	// EventWatchdog is a failed watchdog refresh, see Err.
+	EventWatchdog
+)
+
+// Event is something that happened on one of the adapters.
+type Event struct {
+	Adapter string
+	Time    time.Time
+	Kind    EventKind
+	Message serial.CommandID
+	Old     serial.State
+	New     serial.State
+	Err     error
+}
+
+// Health is what the manager knows about the condition of an adapter.
+type Health struct {
+	State serial.State
+	// Since is when the port entered State, zero for ports added with Add.
+	Since       time.Time
+	Disconnects int
+	// LastEvent is the time of the last event of the adapter.
+	LastEvent time.Time
+}
+
+func (h Health) Healthy() bool {
+	return h.State == serial.StateReady
+}
+
+type adapter struct {
+	name   string
+	port   *serial.Port
+	panID  uint16
+	ieee   uint64
+	health Health
+	// removed is set once the adapter is removed, the events its port sends while
+	// closing are dropped.
+	removed bool
+}
+
+type Manager struct {
+	lock     sync.RWMutex
+	adapters map[string]*adapter
+	events   chan Event
+	dropped  uint64
+	closed   bool
+}
+
+// New returns a manager whose event stream buffers up to buffer events.
+func New(buffer int) *Manager {
+	return &Manager{
+		adapters: make(map[string]*adapter),
+		events:   make(chan Event, buffer),
+	}
+}
+
+// Events returns the events of all adapters. Events are dropped instead of stalling the
+// ports when nobody reads them, see Dropped. The channel is closed by Close.
+func (m *Manager) Events() <-chan Event {
+	return m.events
+}
+
+// Dropped is the number of events that did not fit in the event stream.
+func (m *Manager) Dropped() uint64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.dropped
+}
+
+// Open opens the stick at path, see serial.OpenWithOptions. An empty name names the adapter
+// after its IEEE address.
+func (m *Manager) Open(name, path string, options *serial.Options) (*serial.Port, error) {
+	a := &adapter{}
+	port, err := serial.OpenWithOptions(path, m.handlers(a), options)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.add(name, a, port); err != nil {
+		port.Close()
+		return nil, err
+	}
+	return port, nil
+}
+
+// OpenTransport runs an adapter over conn, see serial.OpenTransportWithOptions.
+func (m *Manager) OpenTransport(name string, conn io.ReadWriteCloser, options *serial.Options) (*serial.Port, error) {
+	a := &adapter{}
+	port, err := serial.OpenTransportWithOptions(conn, m.handlers(a), options)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.add(name, a, port); err != nil {
+		port.Close()
+		return nil, err
+	}
+	return port, nil
+}
+
+// Add adds a port that was opened elsewhere. The manager can not see its events, its health
+// only reflects the state of the port. The port is left open when Add fails, closing it is
+// up to the caller.
+func (m *Manager) Add(name string, port *serial.Port) error {
+	return m.add(name, &adapter{}, port)
+}
+
+func (m *Manager) add(name string, a *adapter, port *serial.Port) error {
+	ieee, panID := uint64(0), uint16(0)
+	err := port.ReadParameter(serial.ParamMACAddress, &ieee)
+	if err == nil {
+		err = port.ReadParameter(serial.ParamNWKPANID, &panID)
+	}
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = fmt.Sprintf("%.16x", ieee)
+	}
+	m.lock.Lock()
+	if m.closed || m.adapters[name] != nil {
+		m.lock.Unlock()
+		if m.closed {
+			return ErrClosed
+		}
+		return ErrDuplicate
+	}
+	a.name = name
+	a.port = port
+	a.ieee = ieee
+	a.panID = panID
+	m.adapters[name] = a
+	m.lock.Unlock()
+	return nil
+}
+
+// Refresh reads the network of an adapter again, e.g. after it has formed a new network.
+func (m *Manager) Refresh(name string) error {
+	port := m.Port(name)
+	if port == nil {
+		return ErrUnknown
+	}
+	panID := uint16(0)
+	if err := port.ReadParameter(serial.ParamNWKPANID, &panID); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if a, ok := m.adapters[name]; ok {
+		a.panID = panID
+	}
+	return nil
+}
+
+// Remove closes the port of an adapter and forgets it. No events of the adapter are sent
+// once Remove is called.
+func (m *Manager) Remove(name string) error {
+	m.lock.Lock()
+	a, ok := m.adapters[name]
+	if ok {
+		a.removed = true
+		delete(m.adapters, name)
+	}
+	m.lock.Unlock()
+	if !ok {
+		return ErrUnknown
+	}
+	return a.port.Close()
+}
+
+// Port returns the port of an adapter, or nil.
+func (m *Manager) Port(name string) *serial.Port {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if a, ok := m.adapters[name]; ok {
+		return a.port
+	}
+	return nil
+}
+
+// ByIEEE returns the name of the adapter with the IEEE address ieee.
+func (m *Manager) ByIEEE(ieee uint64) (string, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for name, a := range m.adapters {
+		if a.ieee == ieee {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Names returns the names of all adapters, sorted.
+func (m *Manager) Names() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	res := make([]string, 0, len(m.adapters))
+	for name := range m.adapters {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (m *Manager) Health(name string) (Health, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	a, ok := m.adapters[name]
+	if !ok {
+		return Health{}, ErrUnknown
+	}
+	h := a.health
+	h.State = a.port.State()
+	return h, nil
+}
+
+// Route returns the name and port of the adapter running the network with PAN id panID.
+func (m *Manager) Route(panID uint16) (string, *serial.Port, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var res *adapter
+	for _, a := range m.adapters {
+		if a.panID != panID {
+			continue
+		}
+		if res != nil {
+			return "", nil, ErrAmbiguousPAN
+		}
+		res = a
+	}
+	if res == nil {
+		return "", nil, ErrNoRoute
+	}
+	return res.name, res.port, nil
+}
+
+// SendData sends data with the adapter of the network panID, see serial.Port.SendData.
+func (m *Manager) SendData(panID uint16, reqID uint8, dstAddr serial.Address, profileID, clusterID uint16, srcEP uint8, data []byte, opts serial.TXOptions, radius uint8, srcRoute ...uint16) (*serial.SendDataResponse, error) {
+	_, port, err := m.Route(panID)
+	if err != nil {
+		return nil, err
+	}
+	return port.SendData(reqID, dstAddr, profileID, clusterID, srcEP, data, opts, radius, srcRoute...)
+}
+
+// Close closes all ports and the event stream.
+func (m *Manager) Close() error {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return ErrClosed
+	}
+	m.closed = true
+	adapters := m.adapters
+	m.adapters = make(map[string]*adapter)
+	m.lock.Unlock()
+	for _, a := range adapters {
+		a.port.Close()
+	}
+	// Closed ports call no handlers, nothing sends on events anymore.
+	close(m.events)
+	return nil
+}
+
+func (m *Manager) handlers(a *adapter) *serial.Handlers {
+	return &serial.Handlers{
+		UnsolicitedHandler: func(p *serial.Port, msg serial.CommandID) {
+			m.emit(a, Event{Kind: EventUnsolicited, Message: msg})
+		},
+		DisconnectHandler: func(p *serial.Port) {
+			m.emit(a, Event{Kind: EventDisconnected})
+		},
+		ReconnectHandler: func(p *serial.Port) {
+			m.emit(a, Event{Kind: EventReconnected})
+		},
+		WatchdogHandler: func(p *serial.Port, err error) {
+			m.emit(a, Event{Kind: EventWatchdog, Err: err})
+		},
+		StateHandler: func(p *serial.Port, old, new serial.State) {
+			m.emit(a, Event{Kind: EventState, Old: old, New: new})
+		},
+	}
+}
+
+// emit updates the health of a and sends ev to the event stream. Events of adapters that
+// are still being added are only used for their health, those of removed adapters are dropped.
+func (m *Manager) emit(a *adapter, ev Event) {
+	ev.Time = time.Now()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	a.health.LastEvent = ev.Time
+	switch ev.Kind {
+	case EventState:
+		a.health.Since = ev.Time
+	case EventDisconnected:
+		a.health.Disconnects++
+	}
+	if m.closed || a.removed || a.name == "" {
+		return
+	}
+	ev.Adapter = a.name
+	select {
+	case m.events <- ev:
+	default:
+		m.dropped++
+	}
+}
@@ -0,0 +1,126 @@
+package manager
+
+import (
+	"encoding/binary"
+	"github.com/daedaluz/goconbee/emulator"
+	"github.com/daedaluz/goconbee/serial"
+	"net"
+	"testing"
+	"time"
+)
+
+func addEmulated(t *testing.T, m *Manager, name string, mac uint64, panID uint16) *emulator.Emulator {
+	t.Helper()
+	store := emulator.NewParameterStore()
+	store.Set(serial.ParamMACAddress, binary.LittleEndian.AppendUint64(nil, mac))
+	store.Set(serial.ParamNWKPANID, binary.LittleEndian.AppendUint16(nil, panID))
+	e := emulator.New(store)
+	local, remote := net.Pipe()
+	go e.Serve(remote)
+	t.Cleanup(func() { remote.Close() })
+	if _, err := m.OpenTransport(name, local, nil); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestManager(t *testing.T) {
+	m := New(10)
+	defer m.Close()
+	sent := make(chan string, 2)
+	addEmulated(t, m, "", 0x00212effff000001, 0x1111).SendHandler = func(req *serial.SendDataRequest) uint8 {
+		sent <- "first"
+		return 0
+	}
+	second := addEmulated(t, m, "second", 0x00212effff000002, 0x2222)
+	second.SendHandler = func(req *serial.SendDataRequest) uint8 {
+		sent <- "second"
+		return 0
+	}
+	if names := m.Names(); len(names) != 2 || names[0] != "00212effff000001" || names[1] != "second" {
+		t.Fatal("unexpected names", names)
+	}
+	if name, ok := m.ByIEEE(0x00212effff000002); !ok || name != "second" {
+		t.Fatal("expected second, got", name)
+	}
+
+	dst := serial.Address{Mode: serial.AddressNWK, Short: 0x1234, Endpoint: 1}
+	if _, err := m.SendData(0x2222, 1, dst, 0x0104, 0x0006, 1, []byte{1}, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if x := <-sent; x != "second" {
+		t.Fatal("routed to", x)
+	}
+	if _, err := m.SendData(0x3333, 1, dst, 0x0104, 0x0006, 1, []byte{1}, 0, 0); err != ErrNoRoute {
+		t.Fatal("expected", ErrNoRoute, "got", err)
+	}
+
+	second.SetNetworkState(serial.NetOffline)
+	deadline := time.After(time.Second)
+wait:
+	for {
+		select {
+		case ev := <-m.Events():
+			if ev.Kind != EventUnsolicited {
+				continue
+			}
+			changed, ok := ev.Message.(*serial.DeviceStateChanged)
+			if ev.Adapter == "second" && ok && changed.NetworkState == serial.NetOffline {
+				break wait
+			}
+		case <-deadline:
+			t.Fatal("no event from second")
+		}
+	}
+	h, err := m.Health("second")
+	if err != nil || !h.Healthy() || h.Since.IsZero() {
+		t.Fatal("unexpected health", h, err)
+	}
+	if err := m.Remove("second"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Health("second"); err != ErrUnknown {
+		t.Fatal("expected", ErrUnknown, "got", err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	m := New(10)
+	defer m.Close()
+	addEmulated(t, m, "first", 0x00212effff000001, 0x1111)
+	if err := m.Remove("first"); err != nil {
+		t.Fatal(err)
+	}
+	// Closing the port changed its state, the event must not be sent for the removed adapter.
+	for {
+		select {
+		case ev := <-m.Events():
+			if ev.Adapter == "first" && ev.Kind == EventState && ev.New == serial.StateClosed {
+				t.Fatal("event of removed adapter", ev)
+			}
+			continue
+		default:
+		}
+		break
+	}
+}
+
+func TestAddDuplicate(t *testing.T) {
+	m := New(10)
+	defer m.Close()
+	addEmulated(t, m, "first", 0x00212effff000001, 0x1111)
+	local, remote := net.Pipe()
+	go emulator.New(nil).Serve(remote)
+	defer remote.Close()
+	port, err := serial.OpenTransport(local, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+	if err := m.Add("first", port); err != ErrDuplicate {
+		t.Fatal("expected", ErrDuplicate, "got", err)
+	}
+	if _, err := port.ReadFirmwareVersion(); err != nil {
+		t.Fatal("port closed by failed Add:", err)
+	}
+}
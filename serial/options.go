@@ -0,0 +1,198 @@
+package serial
+
+import (
+	"fmt"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"github.com/daedaluz/goserial"
+	"log"
+	"time"
+)
+
+// Options configures a Port, start from NewOptions and change what is needed.
+type Options struct {
+	// BaudRate of the tty, RaspBee (I) runs at 38400, everything else at 115200.
+	BaudRate int
+	// ReadTimeout is how long a read of the tty or TCP connection blocks, the reader wakes up
+	// at least this often. Commands time out on their own timers, see CommandTimeout.
+	ReadTimeout time.Duration
+	// QueueSize is the number of commands that can be queued before callers block, or fail
+	// with ErrQueueFull for the Async methods, see Future.
+	QueueSize int
+	// NonBlocking fails commands with ErrQueueFull instead of blocking when the queue is full.
+	NonBlocking bool
+	// Window is the number of commands that may be in flight at once, see Port.SetWindow.
+	Window int
+	// Deprecated: CommandHandlers is Window.
+	CommandHandlers int
+	// CommandTimeout is how long to wait for the response to a command.
+	CommandTimeout time.Duration
+	Logger         *log.Logger
+	// Handshake reads the firmware and protocol version when the port is opened, see Port.Handshake.
+	Handshake bool
+	// WatchdogTTL starts the watchdog when the port is opened, see Port.StartWatchdog.
+	WatchdogTTL      time.Duration
+	WatchdogInterval time.Duration
+	// Retry is the retry policy of idempotent commands, see RetryPolicy.
+	Retry RetryPolicy
+	// BusyRetry is the retry policy of commands the firmware answers with frame.StatusBusy,
+	// BusyRetryCommands overrides it per command. Set Attempts to 1 to disable it.
+	BusyRetry         RetryPolicy
+	BusyRetryCommands map[frame.Command]RetryPolicy
+	// Interceptors are added to the port before it reads or writes the first frame, so they
+	// see the handshake and the watchdog setup as well, see Port.AddInterceptor.
+	Interceptors []Interceptor
+}
+
+func NewOptions() *Options {
+	return &Options{
+		BaudRate:       115200,
+		ReadTimeout:    time.Second,
+		QueueSize:      100,
+		Window:         1,
+		CommandTimeout: time.Second * 7,
+		Logger:         log.Default(),
+		BusyRetry: RetryPolicy{
+			Attempts:   5,
+			Backoff:    time.Millisecond * 25,
+			MaxBackoff: time.Millisecond * 400,
+			Jitter:     0.2,
+		},
+	}
+}
+
+func (o *Options) SetBaudRate(baud int) *Options {
+	o.BaudRate = baud
+	return o
+}
+
+func (o *Options) SetReadTimeout(timeout time.Duration) *Options {
+	o.ReadTimeout = timeout
+	return o
+}
+
+func (o *Options) SetQueueSize(size int) *Options {
+	o.QueueSize = size
+	return o
+}
+
+func (o *Options) SetNonBlocking(nonBlocking bool) *Options {
+	o.NonBlocking = nonBlocking
+	return o
+}
+
+func (o *Options) SetWindow(n int) *Options {
+	o.Window = n
+	return o
+}
+
+// Deprecated: SetCommandHandlers is SetWindow.
+func (o *Options) SetCommandHandlers(n int) *Options {
+	return o.SetWindow(n)
+}
+
+func (o *Options) SetCommandTimeout(timeout time.Duration) *Options {
+	o.CommandTimeout = timeout
+	return o
+}
+
+func (o *Options) SetLogger(logger *log.Logger) *Options {
+	o.Logger = logger
+	return o
+}
+
+func (o *Options) SetHandshake(handshake bool) *Options {
+	o.Handshake = handshake
+	return o
+}
+
+func (o *Options) SetWatchdog(ttl, interval time.Duration) *Options {
+	o.WatchdogTTL = ttl
+	o.WatchdogInterval = interval
+	return o
+}
+
+func (o *Options) SetRetry(policy RetryPolicy) *Options {
+	o.Retry = policy
+	return o
+}
+
+func (o *Options) SetBusyRetry(policy RetryPolicy) *Options {
+	o.BusyRetry = policy
+	return o
+}
+
+// SetCommandBusyRetry overrides the BusyRetry policy of cmd.
+func (o *Options) SetCommandBusyRetry(cmd frame.Command, policy RetryPolicy) *Options {
+	if o.BusyRetryCommands == nil {
+		o.BusyRetryCommands = map[frame.Command]RetryPolicy{}
+	}
+	o.BusyRetryCommands[cmd] = policy
+	return o
+}
+
+func (o *Options) AddInterceptor(i Interceptor) *Options {
+	o.Interceptors = append(o.Interceptors, i)
+	return o
+}
+
+// withDefaults returns a copy of o where unset values are replaced by their defaults.
+func (o *Options) withDefaults() Options {
+	res := *NewOptions()
+	if o == nil {
+		return res
+	}
+	if o.BaudRate > 0 {
+		res.BaudRate = o.BaudRate
+	}
+	if o.ReadTimeout > 0 {
+		res.ReadTimeout = o.ReadTimeout
+	}
+	if o.QueueSize > 0 {
+		res.QueueSize = o.QueueSize
+	}
+	if o.Window > 0 {
+		res.Window = o.Window
+	} else if o.CommandHandlers > 0 {
+		res.Window = o.CommandHandlers
+	}
+	if o.CommandTimeout > 0 {
+		res.CommandTimeout = o.CommandTimeout
+	}
+	if o.Logger != nil {
+		res.Logger = o.Logger
+	}
+	res.NonBlocking = o.NonBlocking
+	res.Handshake = o.Handshake
+	res.WatchdogTTL = o.WatchdogTTL
+	res.WatchdogInterval = o.WatchdogInterval
+	res.Retry = o.Retry
+	if o.BusyRetry != (RetryPolicy{}) {
+		res.BusyRetry = o.BusyRetry
+	}
+	if len(o.BusyRetryCommands) > 0 {
+		res.BusyRetryCommands = make(map[frame.Command]RetryPolicy, len(o.BusyRetryCommands))
+		for cmd, policy := range o.BusyRetryCommands {
+			res.BusyRetryCommands[cmd] = policy
+		}
+	}
+	res.Interceptors = append([]Interceptor(nil), o.Interceptors...)
+	return res
+}
+
+var baudRates = map[int]serial.CFlag{
+	9600:   serial.B9600,
+	19200:  serial.B19200,
+	38400:  serial.B38400,
+	57600:  serial.B57600,
+	115200: serial.B115200,
+	230400: serial.B230400,
+	460800: serial.B460800,
+	921600: serial.B921600,
+}
+
+func baudRate(baud int) (serial.CFlag, error) {
+	if speed, ok := baudRates[baud]; ok {
+		return speed, nil
+	}
+	return 0, fmt.Errorf("unsupported baud rate %d", baud)
+}
@@ -2,14 +2,20 @@ package serial
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/daedaluz/fdev/poll"
 	"github.com/daedaluz/goconbee/serial/frame"
 	"github.com/daedaluz/goserial"
 	"github.com/daedaluz/goslip"
+	"io"
 	"log"
+	"net"
+	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -38,26 +44,123 @@ func (a Address) String() string {
 }
 
 type Port struct {
-	rs232       *serial.Port
-	buf         *bufio.Reader
-	rw          slip.ReadWriter
-	cmdCh       chan command
-	handlers    *Handlers
-	cmdHandlers []*commandHandler
-	seq         atomic.Uint64
-	lastPoll    time.Time
+	connLock sync.Mutex
+	conn     io.ReadWriteCloser
+	buf      *bufio.Reader
+	rw       slip.ReadWriter
+	dial     func() (io.ReadWriteCloser, error)
+	queue    *commandQueue
+	handlers *Handlers
+	options  Options
+	log      *log.Logger
+
+	// inflightLock guards the commands that have been started and their sequence numbers.
+	inflightLock sync.Mutex
+	inflight     map[inflightKey]command
+	seqInUse     [maxWindow]bool
+	nextSeq      uint8
+	window       int
+	// seqHeld are the sequence numbers of completed commands whose response has not been
+	// seen, see allocSeq.
+	seqHeld [maxWindow]heldSeq
+	// parked are the commands in flight when the port recovered, they are sent again once
+	// the RecoveryHandler is done and don't count against the window until then.
+	parked map[inflightKey]bool
+	// room wakes up dispatch when a command has completed or the window has changed.
+	room chan struct{}
+
+	// closeLock is held for reading while commands are queued and goroutines are started,
+	// once closed is closed and the write lock has been taken neither will happen again.
+	closeLock sync.RWMutex
+	closed    chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	stateLock sync.Mutex
+	state     State
+	// stateChanges are waiting for the StateHandler, delivering is set while one goroutine
+	// reports them.
+	stateChanges []stateChange
+	delivering   bool
+	timeouts     atomic.Int32
+
+	closing         atomic.Bool
+	onlineLock      sync.Mutex
+	online          chan struct{}
+	recoveryEnabled bool
+	recovery        RecoveryHandler
+
+	featureLock  sync.Mutex
+	firmware     *FirmwareVersion
+	protocol     uint16
+	capabilities Capabilities
+
+	watchdogLock sync.Mutex
+	watchdog     *watchdog
+
+	dataPumpLock sync.Mutex
+	dataPump     *DataPump
+
+	interceptLock sync.RWMutex
+	interceptors  []*interceptorEntry
 }
 
 type DisconnectHandler func(p *Port)
 
+// ReconnectHandler is called when a transport that reconnects by itself, like tcp, is back online.
+type ReconnectHandler func(p *Port)
+
 type Handlers struct {
 	UnsolicitedHandler
 	DisconnectHandler
+	ReconnectHandler
+	WatchdogHandler
+	StateHandler
+}
+
+// portAttacher is implemented by transports that report back to the port they serve.
+type portAttacher interface {
+	attach(p *Port)
 }
 
+// Open opens a local tty, or a remote stick when path is given as tcp://host:port.
 func Open(path string, handlers *Handlers) (*Port, error) {
+	return OpenWithOptions(path, handlers, nil)
+}
+
+func OpenWithOptions(path string, handlers *Handlers, options *Options) (*Port, error) {
+	opts := options.withDefaults()
+	if strings.Contains(path, "://") {
+		u, err := url.Parse(path)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "tcp" {
+			return nil, fmt.Errorf("unsupported transport %q", u.Scheme)
+		}
+		conn, err := dialTCP(u.Host, opts.ReadTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return startPort(conn, handlers, nil, opts)
+	}
+	speed, err := baudRate(opts.BaudRate)
+	if err != nil {
+		return nil, err
+	}
+	dial := func() (io.ReadWriteCloser, error) {
+		return openTTY(path, speed, opts.ReadTimeout)
+	}
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return startPort(conn, handlers, dial, opts)
+}
+
+func openTTY(path string, speed serial.CFlag, readTimeout time.Duration) (*serial.Port, error) {
 	options := serial.NewOptions()
-	options.SetReadTimeout(time.Second)
+	options.SetReadTimeout(readTimeout)
 	p, err := serial.Open(path, options)
 	if err != nil {
 		return nil, err
@@ -68,25 +171,70 @@ func Open(path string, handlers *Handlers) (*Port, error) {
 		return nil, err
 	}
 	attrs.MakeRaw()
-	attrs.SetSpeed(serial.B115200)
+	attrs.SetSpeed(speed)
 	if err := p.SetAttr(serial.TCSANOW, attrs); err != nil {
 		p.Close()
 		return nil, err
 	}
+	return p, nil
+}
+
+// OpenTransport runs the conbee protocol over an already established byte stream.
+// Read errors that are timeouts (see isTimeout) are ignored, any other read error
+// is treated as a disconnect.
+func OpenTransport(conn io.ReadWriteCloser, handlers *Handlers) (*Port, error) {
+	return OpenTransportWithOptions(conn, handlers, nil)
+}
+
+// OpenTransportWithOptions is OpenTransport with options, BaudRate and ReadTimeout
+// are up to whoever set up conn.
+func OpenTransportWithOptions(conn io.ReadWriteCloser, handlers *Handlers, options *Options) (*Port, error) {
+	return startPort(conn, handlers, nil, options.withDefaults())
+}
 
-	br := bufio.NewReader(p)
-	rw := slip.NewReadWriter2(br, p)
+// startPort opens the port, does the handshake and starts the watchdog if the options ask for it.
+func startPort(conn io.ReadWriteCloser, handlers *Handlers, dial func() (io.ReadWriteCloser, error), opts Options) (*Port, error) {
+	port := openTransport(conn, handlers, dial, opts)
+	if opts.Handshake {
+		if err := port.Handshake(); err != nil {
+			port.Close()
+			return nil, err
+		}
+	} else {
+		port.setState(StateReady)
+	}
+	if opts.WatchdogTTL > 0 {
+		if err := port.StartWatchdog(opts.WatchdogTTL, opts.WatchdogInterval); err != nil {
+			port.Close()
+			return nil, err
+		}
+	}
+	return port, nil
+}
 
+// openTransport starts a port on conn. If dial is not nil it is used to reopen the
+// device when recovery is enabled.
+func openTransport(conn io.ReadWriteCloser, handlers *Handlers, dial func() (io.ReadWriteCloser, error), opts Options) *Port {
 	defaultUnsolicitedHandler := func(p *Port, f CommandID) {
-		log.Println("Unhandled: ", f)
+		p.log.Println("Unhandled: ", f)
 	}
 	defaultDisconnectHandler := func(p *Port) {
-		log.Println("Conbee disconnected")
+		p.log.Println("Conbee disconnected")
+	}
+	defaultReconnectHandler := func(p *Port) {
+		p.log.Println("Conbee reconnected")
+	}
+	defaultWatchdogHandler := func(p *Port, err error) {
+		p.log.Println("Conbee", err)
 	}
+	defaultStateHandler := func(p *Port, old, new State) {}
 	if handlers == nil {
 		handlers = &Handlers{
 			UnsolicitedHandler: defaultUnsolicitedHandler,
 			DisconnectHandler:  defaultDisconnectHandler,
+			ReconnectHandler:   defaultReconnectHandler,
+			WatchdogHandler:    defaultWatchdogHandler,
+			StateHandler:       defaultStateHandler,
 		}
 	}
 	if handlers.UnsolicitedHandler == nil {
@@ -95,50 +243,116 @@ func Open(path string, handlers *Handlers) (*Port, error) {
 	if handlers.DisconnectHandler == nil {
 		handlers.DisconnectHandler = defaultDisconnectHandler
 	}
+	if handlers.ReconnectHandler == nil {
+		handlers.ReconnectHandler = defaultReconnectHandler
+	}
+	if handlers.WatchdogHandler == nil {
+		handlers.WatchdogHandler = defaultWatchdogHandler
+	}
+	if handlers.StateHandler == nil {
+		handlers.StateHandler = defaultStateHandler
+	}
 
 	port := &Port{
-		rs232:       p,
-		buf:         br,
-		rw:          rw,
-		handlers:    handlers,
-		cmdCh:       make(chan command, 100),
-		cmdHandlers: make([]*commandHandler, 0, 10),
-	}
-	port.cmdHandlers = append(port.cmdHandlers, newCommandHandler(port))
-	for _, handler := range port.cmdHandlers {
-		go handler.start()
-	}
-	go port.rx()
-	return port, nil
+		dial:     dial,
+		handlers: handlers,
+		options:  opts,
+		log:      opts.Logger,
+		online:   make(chan struct{}),
+		closed:   make(chan struct{}),
+		queue:    newCommandQueue(opts.QueueSize),
+		inflight: make(map[inflightKey]command),
+		parked:   make(map[inflightKey]bool),
+		window:   1,
+		room:     make(chan struct{}, 1),
+	}
+	for _, i := range opts.Interceptors {
+		port.interceptors = append(port.interceptors, &interceptorEntry{fn: i})
+	}
+	close(port.online)
+	port.replaceConn(conn)
+	if a, ok := conn.(portAttacher); ok {
+		a.attach(port)
+	}
+	port.SetWindow(opts.Window)
+	port.spawn(port.dispatch)
+	rw := port.reader()
+	port.spawn(func() { port.rx(rw) })
+	return port
 }
 
-func (p *Port) SetNHandlers(n int) {
-	if len(p.cmdHandlers) == n {
-		return
+// replaceConn closes the current connection, if any, and continues on conn.
+// It reports false if the port has been closed.
+func (p *Port) replaceConn(conn io.ReadWriteCloser) bool {
+	p.connLock.Lock()
+	defer p.connLock.Unlock()
+	if p.closing.Load() {
+		conn.Close()
+		return false
 	}
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn = conn
+	p.buf = bufio.NewReader(conn)
+	p.rw = slip.NewReadWriter2(p.buf, conn)
+	return true
+}
 
-	newHandlers := make([]*commandHandler, n)
+// spawn runs f in a goroutine that Close waits for. It reports false if the port has been closed.
+func (p *Port) spawn(f func()) bool {
+	p.closeLock.RLock()
+	defer p.closeLock.RUnlock()
+	if p.closing.Load() {
+		return false
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		f()
+	}()
+	return true
+}
 
-	if n < len(p.cmdHandlers) {
-		for i, handler := range p.cmdHandlers {
-			if i < n {
-				newHandlers[i] = p.cmdHandlers[i]
-			} else {
-				handler.exitCh <- true
-			}
+// submit queues cmd in class prio, if the port is closed the command fails with ErrClosed
+// and it is completed with ctx.Err() if ctx is done before it could be queued. In
+// non-blocking mode a full queue fails the command with ErrQueueFull.
+func (p *Port) submit(ctx context.Context, prio Priority, cmd command) {
+	p.closeLock.RLock()
+	defer p.closeLock.RUnlock()
+	if p.closing.Load() {
+		cmd.complete(ErrClosed)
+		return
+	}
+	if p.nonBlocking(ctx) {
+		select {
+		case p.queue.space <- struct{}{}:
+			p.queue.push(prio, cmd)
+		default:
+			p.queue.reject()
+			cmd.complete(ErrQueueFull)
 		}
 		return
 	}
-	for x := len(p.cmdHandlers); x < n; x++ {
-		handler := newCommandHandler(p)
-		go handler.start()
-		p.cmdHandlers = append(p.cmdHandlers, handler)
+	select {
+	case <-p.closed:
+		cmd.complete(ErrClosed)
+	case <-ctx.Done():
+		cmd.complete(ctx.Err())
+	case p.queue.space <- struct{}{}:
+		p.queue.push(prio, cmd)
 	}
 }
 
-func (p *Port) readFrame() (frame.Frame, error) {
+func (p *Port) reader() slip.ReadWriter {
+	p.connLock.Lock()
+	defer p.connLock.Unlock()
+	return p.rw
+}
+
+func (p *Port) readFrame(rw slip.ReadWriter) (frame.Frame, error) {
 	for {
-		x, err := p.rw.ReadPacket()
+		x, err := rw.ReadPacket()
 		if err != nil {
 			return nil, err
 		}
@@ -149,70 +363,102 @@ func (p *Port) readFrame() (frame.Frame, error) {
 }
 
 func (p *Port) writeFrame(f frame.Frame) error {
-	p.rs232.Write([]byte{0300})
+	f, ok := p.intercept(DirectionTX, f)
+	if !ok {
+		return ErrDropped
+	}
+	p.connLock.Lock()
+	defer p.connLock.Unlock()
+	p.conn.Write([]byte{0300})
 	return p.rw.WritePacket(f)
 }
 
-func (p *Port) rx() {
+func (p *Port) rx(rw slip.ReadWriter) {
 	var f frame.Frame
 	var err error
-outerLoop:
-	for f, err = p.readFrame(); err == nil || errors.Is(err, poll.ErrTimeout); f, err = p.readFrame() {
-		now := time.Now()
-		if now.Sub(p.lastPoll) > time.Second {
-			for _, handler := range p.cmdHandlers {
-				handler.ping(now)
-			}
-		}
-		if errors.Is(err, poll.ErrTimeout) {
+	var ok bool
+	for f, err = p.readFrame(rw); err == nil || isTimeout(err); f, err = p.readFrame(rw) {
+		if isTimeout(err) {
+			continue
+		}
+		if f, ok = p.intercept(DirectionRX, f); !ok {
 			continue
 		}
 		if !f.CheckCRC() {
 			continue
 		}
-		for _, handler := range p.cmdHandlers {
-			if handler.handle(f, nil) {
-				continue outerLoop
-			}
+		cmd := p.inflightCommand(f)
+		handled := cmd != nil && cmd.handle(p, f)
+		p.seqAnswered(f)
+		if handled {
+			continue
 		}
-		x := CommandID(f)
+		var msg response
 		switch f.CommandID() {
 		case frame.CmdMacBeaconIndication:
-			tmp := &MacBeaconIndication{}
-			tmp.decode(f)
-			x = CommandID(tmp)
+			msg = &MacBeaconIndication{}
 		case frame.CmdMacPollIndication:
-			tmp := &MacPollIndication{}
-			tmp.decode(f)
-			x = CommandID(tmp)
+			msg = &MacPollIndication{}
 		case frame.CmdDeviceStateChanged:
-			tmp := &DeviceStateChanged{}
-			tmp.decode(f)
-			x = CommandID(tmp)
+			msg = &DeviceStateChanged{}
 		case frame.CmdGreenPower:
-			tmp := &GreenPower{}
-			tmp.decode(f)
-			x = CommandID(tmp)
+			msg = &GreenPower{}
+		}
+		// Frames that can not be decoded are passed on as they are.
+		x := CommandID(f)
+		if msg != nil && msg.decode(f) == nil {
+			x = msg
+			p.observeDataFlags(msg)
 		}
 		if p.handlers.UnsolicitedHandler != nil {
 			p.handlers.UnsolicitedHandler(p, x)
 		}
 	}
+	if p.closing.Load() || p.recover() {
+		return
+	}
 	p.handlers.DisconnectHandler(p)
-	p.Close()
+	p.shutdown()
 }
 
-func (p *Port) getSeqNumber() uint8 {
-	x := p.seq.Add(1) % 255
-	return uint8(x)
+func isTimeout(err error) bool {
+	if errors.Is(err, poll.ErrTimeout) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
+// Close fails all queued and in-flight commands with ErrClosed and returns once
+// every goroutine of the port has exited. Calling Close more than once is fine,
+// but as it waits for the goroutines that run the Handlers it must not be called from one.
 func (p *Port) Close() error {
-	for _, handler := range p.cmdHandlers {
-		select {
-		case handler.exitCh <- true:
-		default:
+	err := p.shutdown()
+	p.wg.Wait()
+	return err
+}
+
+// shutdown closes the port without waiting for its goroutines.
+func (p *Port) shutdown() error {
+	var err error
+	p.closeOnce.Do(func() {
+		p.closing.Store(true)
+		close(p.closed)
+		p.setState(StateClosed)
+		p.detachWatchdog()
+		p.detachDataPump()
+		// Wait for ongoing submits and spawns, there will be no new ones after this.
+		p.closeLock.Lock()
+		p.closeLock.Unlock()
+		for cmd := p.queue.pop(); cmd != nil; cmd = p.queue.pop() {
+			cmd.complete(ErrClosed)
 		}
-	}
-	return p.rs232.Close()
+		for _, cmd := range p.inflightCommands() {
+			cmd.complete(ErrClosed)
+		}
+		p.connLock.Lock()
+		err = p.conn.Close()
+		p.connLock.Unlock()
+	})
+	return err
 }
@@ -0,0 +1,224 @@
+package serial
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOpenTransport(t *testing.T) {
+	port, _ := newFakeStick(t, nil, nil, versionResponder)
+	version, err := port.ReadFirmwareVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Platform != Conbee2 || version.Major != 0x26 || version.Minor != 0x72 {
+		t.Fatal("unexpected version", version)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+		return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusUnsupported, []byte{0, 0, 0})}
+	})
+	_, err := port.GetDeviceState()
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Command != frame.CmdDeviceState {
+		t.Fatal("expected a status error, got", err)
+	}
+	if !errors.Is(err, frame.StatusUnsupported) || !errors.Is(err, ErrUnsupported) {
+		t.Fatal("expected", frame.StatusUnsupported, "got", err)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+		return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusSuccess, []byte{0x00})}
+	})
+	_, err := port.ReadFirmwareVersion()
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Command != frame.CmdVersion {
+		t.Fatal("expected a decode error, got", err)
+	}
+	if _, err := DecodeApsData(frame.NewFrame(frame.CmdAPSDataIndication, 0, []byte{0x10, 0x00, 0x00, 0x02})); !errors.As(err, &decodeErr) {
+		t.Fatal("expected a decode error, got", err)
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	port, _ := newFakeStick(t, NewOptions().SetReadTimeout(time.Millisecond*50).SetCommandTimeout(time.Millisecond*100), nil, func(f frame.Frame) []frame.Frame { return nil })
+	start := time.Now()
+	if _, err := port.GetDeviceState(); err == nil {
+		t.Fatal("expected timeout")
+	}
+	if time.Since(start) > time.Second*2 {
+		t.Fatal("command timeout not applied")
+	}
+}
+
+func TestContext(t *testing.T) {
+	seen := make(chan frame.Command, 10)
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+		seen <- f.CommandID()
+		return versionResponder(f)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	inFlight := make(chan error)
+	go func() {
+		_, err := port.GetDeviceStateContext(ctx)
+		inFlight <- err
+	}()
+	if cmd := <-seen; cmd != frame.CmdDeviceState {
+		t.Fatal("unexpected", cmd)
+	}
+	queued, cancelQueued := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancelQueued()
+	if _, err := port.ReadFirmwareVersionContext(queued); err != context.DeadlineExceeded {
+		t.Fatal("expected", context.DeadlineExceeded, "got", err)
+	}
+	cancel()
+	if err := <-inFlight; err != context.Canceled {
+		t.Fatal("expected", context.Canceled, "got", err)
+	}
+
+	if _, err := port.ReadFirmwareVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := <-seen; cmd != frame.CmdVersion {
+		t.Fatal("unexpected", cmd)
+	}
+	select {
+	case cmd := <-seen:
+		t.Fatal("cancelled command was sent:", cmd)
+	default:
+	}
+}
+
+func TestDecodeSendDataRequest(t *testing.T) {
+	req := &SendDataRequest{
+		Flags:      sendDataFlagSourceRouting,
+		RequestID:  7,
+		DstAddress: Address{Mode: AddressNWK, Short: 0x1234, Endpoint: 1},
+		ProfileID:  0x0104,
+		ClusterID:  0x0006,
+		SrcEP:      1,
+		Data:       []byte{0x01, 0x02, 0x03},
+		Options:    TXOptUseAPSAck,
+		Radius:     10,
+		Relay:      []uint16{0x1111, 0x2222},
+	}
+	res, err := DecodeSendDataRequest(req.encode(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(req, res) {
+		t.Fatal("expected", req, "got", res)
+	}
+}
+
+func writeAddress(buf *bytes.Buffer, a Address) {
+	buf.WriteByte(byte(a.Mode))
+	switch a.Mode {
+	case AddressGroup, AddressNWK:
+		binary.Write(buf, binary.LittleEndian, a.Short)
+	case AddressIEEE:
+		binary.Write(buf, binary.LittleEndian, a.Extended)
+	case AddressNWKAndIEEE:
+		binary.Write(buf, binary.LittleEndian, a.Short)
+		binary.Write(buf, binary.LittleEndian, a.Extended)
+	}
+	buf.WriteByte(a.Endpoint)
+}
+
+func TestDecodeApsData(t *testing.T) {
+	dst := Address{Mode: AddressNWK, Short: 0x0000, Endpoint: 1}
+	sources := []Address{
+		{Mode: AddressNWK, Short: 0x1234, Endpoint: 2},
+		{Mode: AddressIEEE, Extended: 0x00124b0001020304, Endpoint: 3},
+		{Mode: AddressNWKAndIEEE, Short: 0x1234, Extended: 0x00124b0001020304, Endpoint: 4},
+	}
+	for _, src := range sources {
+		buf := &bytes.Buffer{}
+		buf.Write([]byte{0, 0, byte(NetConnected)})
+		writeAddress(buf, dst)
+		writeAddress(buf, src)
+		binary.Write(buf, binary.LittleEndian, uint16(0x0104))
+		binary.Write(buf, binary.LittleEndian, uint16(0x0006))
+		binary.Write(buf, binary.LittleEndian, uint16(2))
+		buf.Write([]byte{0xaa, 0xbb})
+		binary.Write(buf, binary.LittleEndian, uint16(0x1234))
+		buf.Write([]byte{0xff, 0, 0, 0, 0, 0xc4})
+
+		data, err := DecodeApsData(statusFrame(frame.CmdAPSDataIndication, 1, frame.StatusSuccess, buf.Bytes()))
+		if err != nil {
+			t.Fatal(src.Mode, err)
+		}
+		if data.DstAddress != dst || data.SrcAddress != src {
+			t.Fatal("expected", dst, src, "got", data.DstAddress, data.SrcAddress)
+		}
+		if data.ProfileID != 0x0104 || data.ClusterID != 0x0006 || !bytes.Equal(data.Data, []byte{0xaa, 0xbb}) {
+			t.Fatal(src.Mode, "unexpected payload", data)
+		}
+		if data.LastHop != 0x1234 || data.LQI != 0xff || data.RSSI != -60 {
+			t.Fatal(src.Mode, "unexpected link info", data)
+		}
+	}
+}
+
+func TestDecodeQuerySendData(t *testing.T) {
+	for _, dst := range []Address{
+		{Mode: AddressGroup, Short: 0x0001},
+		{Mode: AddressNWK, Short: 0x1234, Endpoint: 2},
+		{Mode: AddressIEEE, Extended: 0x00124b0001020304, Endpoint: 3},
+	} {
+		buf := &bytes.Buffer{}
+		buf.Write([]byte{0, 0, byte(NetConnected), 7})
+		writeAddress(buf, dst)
+		if dst.Mode == AddressGroup {
+			buf.Truncate(buf.Len() - 1)
+		}
+		buf.Write([]byte{1, 0xe1})
+
+		res := &QuerySendDataResponse{}
+		if err := decodeResponse(res, statusFrame(frame.CmdAPSDataConfirm, 1, frame.StatusSuccess, buf.Bytes())); err != nil {
+			t.Fatal(dst.Mode, err)
+		}
+		if res.RequestID != 7 || res.DstAddress != dst || res.SrcEP != 1 || res.Status != 0xe1 {
+			t.Fatal(dst.Mode, "unexpected response", res)
+		}
+	}
+}
+
+func TestSendRaw(t *testing.T) {
+	busy := make(chan struct{}, 10)
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+		switch f.CommandID() {
+		case frame.Command(0x30):
+			return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusUnsupported, nil)}
+		case frame.Command(0x31):
+			busy <- struct{}{}
+			return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusBusy, nil)}
+		}
+		return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusSuccess, f.Data())}
+	})
+	res, err := port.SendRaw(context.Background(), frame.CmdUpdateBootloader, []byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CommandID() != frame.CmdUpdateBootloader || !res.CheckCRC() || !reflect.DeepEqual(res.Data(), []byte{1, 2, 3}) {
+		t.Fatal("unexpected response", res)
+	}
+	res, err = port.SendRaw(context.Background(), frame.Command(0x30), nil)
+	if !errors.Is(err, frame.StatusUnsupported) || res == nil || res.Status() != frame.StatusUnsupported {
+		t.Fatal("expected", frame.StatusUnsupported, "got", res, err)
+	}
+	if _, err := port.SendRaw(context.Background(), frame.Command(0x31), nil); !errors.Is(err, frame.StatusBusy) || len(busy) != 1 {
+		t.Fatal("expected one attempt failing with", frame.StatusBusy, "got", len(busy), err)
+	}
+}
@@ -0,0 +1,229 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"sync/atomic"
+	"time"
+)
+
+// DataPump reads received data and send confirmations from the stick as soon as the
+// firmware flags them, see Port.StartDataPump.
+type DataPump struct {
+	flags    ReadDataFlag
+	data     chan *ApsData
+	confirms chan *QuerySendDataResponse
+
+	// Set when the firmware has flagged pending data or confirmations, or the device
+	// state has to be read to find out.
+	indication atomic.Bool
+	confirm    atomic.Bool
+	poll       atomic.Bool
+
+	kick   chan struct{}
+	stop   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Data returns the received data, it is closed once the pump has stopped.
+func (d *DataPump) Data() <-chan *ApsData {
+	return d.data
+}
+
+// Confirms returns the send confirmations, it is closed once the pump has stopped.
+func (d *DataPump) Confirms() <-chan *QuerySendDataResponse {
+	return d.confirms
+}
+
+func (d *DataPump) wake() {
+	select {
+	case d.kick <- struct{}{}:
+	default:
+	}
+}
+
+// StartDataPump reads received data with flags and send confirmations whenever the
+// DataIndication and DataConfirm bits of DeviceStateChanged, or of any response that
+// carries the device state, are set, until the firmware queues are empty. The device
+// state is read when the pump starts and after a reconnect.
+//
+// Both channels of the pump buffer up to buffer entries. While one is full the pump
+// waits and leaves the rest in the firmware queues. The pump runs until StopDataPump
+// or Close is called.
+func (p *Port) StartDataPump(flags ReadDataFlag, buffer int) (*DataPump, error) {
+	if flags != 0 {
+		if err := p.checkCapability(CapDataIndicationFlags); err != nil {
+			return nil, err
+		}
+	}
+	p.StopDataPump()
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &DataPump{
+		flags:    flags,
+		data:     make(chan *ApsData, buffer),
+		confirms: make(chan *QuerySendDataResponse, buffer),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	d.poll.Store(true)
+	p.dataPumpLock.Lock()
+	defer p.dataPumpLock.Unlock()
+	if !p.spawn(func() { p.runDataPump(ctx, d) }) {
+		cancel()
+		return nil, ErrClosed
+	}
+	p.dataPump = d
+	return d, nil
+}
+
+// StopDataPump stops the running data pump, if any, and waits for it to close its channels.
+func (p *Port) StopDataPump() {
+	if d := p.detachDataPump(); d != nil {
+		<-d.done
+	}
+}
+
+// detachDataPump tells the running data pump, if any, to stop and returns it.
+func (p *Port) detachDataPump() *DataPump {
+	p.dataPumpLock.Lock()
+	defer p.dataPumpLock.Unlock()
+	d := p.dataPump
+	p.dataPump = nil
+	if d != nil {
+		close(d.stop)
+		d.cancel()
+	}
+	return d
+}
+
+// kickDataPump makes the data pump read the device state, e.g. after a reconnect.
+func (p *Port) kickDataPump() {
+	p.dataPumpLock.Lock()
+	defer p.dataPumpLock.Unlock()
+	if p.dataPump == nil {
+		return
+	}
+	p.dataPump.poll.Store(true)
+	p.dataPump.wake()
+}
+
+// observeDataFlags passes the data bits of x, if it carries the device state, to the data pump.
+func (p *Port) observeDataFlags(x any) {
+	var indication, confirm bool
+	switch s := x.(type) {
+	case *DeviceState:
+		indication, confirm = s.DataIndication, s.DataConfirm
+	case *DeviceStateChanged:
+		indication, confirm = s.DataIndication, s.DataConfirm
+	case *SendDataResponse:
+		indication, confirm = s.DataIndication, s.DataConfirm
+	case *QuerySendDataResponse:
+		indication, confirm = s.DataIndication, s.DataConfirm
+	case *ApsData:
+		indication, confirm = s.DataIndication, s.DataConfirm
+	}
+	if !indication && !confirm {
+		return
+	}
+	p.dataPumpLock.Lock()
+	defer p.dataPumpLock.Unlock()
+	d := p.dataPump
+	if d == nil {
+		return
+	}
+	if indication {
+		d.indication.Store(true)
+	}
+	if confirm {
+		d.confirm.Store(true)
+	}
+	d.wake()
+}
+
+// dataPumpBackoff is the wait before the data pump reads the device state again after a
+// command failed.
+const dataPumpBackoff = time.Millisecond * 250
+
+func (p *Port) runDataPump(ctx context.Context, d *DataPump) {
+	defer close(d.done)
+	defer close(d.confirms)
+	defer close(d.data)
+	// failed logs err and reads the device state again after a while, the flag that led
+	// to the failed command is lost otherwise. Errors because the pump is stopped and
+	// frame.StatusFailure, which the firmware answers when the queue is already empty,
+	// are not failures. It returns false when the pump is stopped.
+	failed := func(err error) bool {
+		if ctx.Err() != nil || errors.Is(err, frame.StatusFailure) {
+			return true
+		}
+		p.log.Println("Conbee data pump:", err)
+		select {
+		case <-d.stop:
+			return false
+		case <-time.After(dataPumpBackoff):
+		}
+		d.poll.Store(true)
+		return true
+	}
+	for {
+		select {
+		case <-d.stop:
+			return
+		default:
+		}
+		// The responses read below carry the device state as well, so the flags are set
+		// again until the firmware queues are empty. A response reporting a flag cleared
+		// may race with new data, the device state is read once more to be sure.
+		if d.poll.Swap(false) {
+			if _, err := p.GetDeviceStateContext(ctx); err != nil && !failed(err) {
+				return
+			}
+		}
+		if d.indication.Swap(false) {
+			data, err := p.ReadReceivedDataContext(ctx, d.flags)
+			if err != nil {
+				if !failed(err) {
+					return
+				}
+			} else {
+				if !data.DataIndication {
+					d.poll.Store(true)
+				}
+				select {
+				case d.data <- data:
+				case <-d.stop:
+					return
+				}
+			}
+		}
+		if d.confirm.Swap(false) {
+			confirm, err := p.QuerySendDataContext(ctx)
+			if err != nil {
+				if !failed(err) {
+					return
+				}
+			} else {
+				if !confirm.DataConfirm {
+					d.poll.Store(true)
+				}
+				select {
+				case d.confirms <- confirm:
+				case <-d.stop:
+					return
+				}
+			}
+		}
+		if d.poll.Load() || d.indication.Load() || d.confirm.Load() {
+			continue
+		}
+		select {
+		case <-d.stop:
+			return
+		case <-d.kick:
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package serial
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"testing"
+	"time"
+)
+
+func TestDataPumpReadFailure(t *testing.T) {
+	pending, reads := 1, 0
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+		state := byte(NetConnected)
+		if pending > 0 {
+			state |= 0b00001000
+		}
+		switch f.CommandID() {
+		case frame.CmdDeviceState:
+			return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusSuccess, []byte{state, 0, 0})}
+		case frame.CmdAPSDataIndication:
+			reads++
+			if reads == 1 {
+				return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusInvalidValue, []byte{1, 0, state})}
+			}
+			if pending == 0 {
+				return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusFailure, []byte{1, 0, state})}
+			}
+			pending--
+			buf := &bytes.Buffer{}
+			buf.Write([]byte{0, 0, byte(NetConnected)})
+			writeAddress(buf, Address{Mode: AddressNWK, Short: 0x0000, Endpoint: 1})
+			writeAddress(buf, Address{Mode: AddressNWK, Short: 0x1234, Endpoint: 1})
+			binary.Write(buf, binary.LittleEndian, []uint16{0x0104, 0x0006, 1})
+			buf.Write([]byte{0xaa, 0, 0, 0xff, 0, 0, 0, 0, 0})
+			return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusSuccess, buf.Bytes())}
+		}
+		return nil
+	})
+	pump, err := port.StartDataPump(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first read fails, the pump has to find the data again by reading the device state.
+	select {
+	case data := <-pump.Data():
+		if !bytes.Equal(data.Data, []byte{0xaa}) {
+			t.Fatal("unexpected data", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("data not pumped after a failed read")
+	}
+	port.StopDataPump()
+}
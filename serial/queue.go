@@ -0,0 +1,211 @@
+package serial
+
+import (
+	"context"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"sync"
+	"time"
+)
+
+// Priority is the class a command is queued in, higher classes are started first.
+type Priority uint8
+
+const (
+	// PriorityControl is for commands that keep the stick running, like refreshing the
+	// watchdog or reading and writing parameters.
+	PriorityControl = Priority(iota)
+	// PriorityPolling is for polls of the device state and the data queues.
+	PriorityPolling
+	// PriorityBulk is for data requests.
+	PriorityBulk
+)
+
+const numPriorities = 3
+
+// starveAfter is how often a waiting class is passed over by higher classes before one of its commands is started.
+const starveAfter = 8
+
+var commandPriority = map[frame.Command]Priority{
+	frame.CmdDeviceState:       PriorityPolling,
+	frame.CmdAPSDataConfirm:    PriorityPolling,
+	frame.CmdAPSDataIndication: PriorityPolling,
+	frame.CmdAPSDataRequest:    PriorityBulk,
+}
+
+// WithPriority queues the commands ctx is passed to in class prio instead of the default
+// class of the command.
+func WithPriority(ctx context.Context, prio Priority) context.Context {
+	return context.WithValue(ctx, priorityKey, prio)
+}
+
+func (p *Port) priority(ctx context.Context, cmd frame.Command) Priority {
+	if prio, ok := ctx.Value(priorityKey).(Priority); ok && prio < numPriorities {
+		return prio
+	}
+	return commandPriority[cmd]
+}
+
+// WithNonBlocking makes the commands ctx is passed to fail with ErrQueueFull instead of
+// waiting for room in a full queue, see also Options.NonBlocking.
+func WithNonBlocking(ctx context.Context) context.Context {
+	return context.WithValue(ctx, nonBlockingKey, true)
+}
+
+func (p *Port) nonBlocking(ctx context.Context) bool {
+	if nonBlocking, ok := ctx.Value(nonBlockingKey).(bool); ok {
+		return nonBlocking
+	}
+	return p.options.NonBlocking
+}
+
+// QueueStats is a snapshot of the command queue of a port.
+type QueueStats struct {
+	// Queued is the number of commands waiting for a place in the window.
+	Queued   int
+	InFlight int
+	Window   int
+	// Rejected is the number of commands that failed with ErrQueueFull.
+	Rejected   uint64
+	Priorities [numPriorities]PriorityStats
+}
+
+// PriorityStats are the queue statistics of one priority class.
+type PriorityStats struct {
+	Queued int
+	// OldestWait is how long the oldest queued command has been waiting.
+	OldestWait time.Duration
+	// Started is the number of commands that left the queue for the window, TotalWait
+	// and MaxWait are the time they spent in the queue.
+	Started   uint64
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+func (s PriorityStats) AverageWait() time.Duration {
+	if s.Started == 0 {
+		return 0
+	}
+	return s.TotalWait / time.Duration(s.Started)
+}
+
+func (p *Port) QueueStats() QueueStats {
+	res := p.queue.stats(time.Now())
+	p.inflightLock.Lock()
+	res.InFlight = len(p.inflight)
+	res.Window = p.window
+	p.inflightLock.Unlock()
+	return res
+}
+
+type queueEntry struct {
+	cmd    command
+	queued time.Time
+}
+
+// commandQueue holds the commands that wait for a place in the window, a FIFO per priority.
+type commandQueue struct {
+	lock     sync.Mutex
+	classes  [numPriorities][]queueEntry
+	skipped  [numPriorities]int
+	started  [numPriorities]PriorityStats
+	rejected uint64
+	// space holds a token for every queued command, sending blocks while the queue is full.
+	space chan struct{}
+	// ready wakes up dispatch when a command has been queued.
+	ready chan struct{}
+}
+
+func newCommandQueue(size int) *commandQueue {
+	return &commandQueue{
+		space: make(chan struct{}, size),
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// push queues cmd, the caller must have put a token in space.
+func (q *commandQueue) push(prio Priority, cmd command) {
+	q.lock.Lock()
+	q.classes[prio] = append(q.classes[prio], queueEntry{cmd: cmd, queued: time.Now()})
+	q.lock.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the command to start next, or nil if the queue is empty. The highest class
+// goes first, unless a lower class has been passed over starveAfter times.
+func (q *commandQueue) pop() command {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	pick := -1
+	for prio := numPriorities - 1; prio >= 0; prio-- {
+		if len(q.classes[prio]) > 0 && q.skipped[prio] >= starveAfter {
+			pick = prio
+			break
+		}
+	}
+	for prio := 0; prio < numPriorities && pick < 0; prio++ {
+		if len(q.classes[prio]) > 0 {
+			pick = prio
+		}
+	}
+	if pick < 0 {
+		return nil
+	}
+	for prio := pick + 1; prio < numPriorities; prio++ {
+		if len(q.classes[prio]) > 0 {
+			q.skipped[prio]++
+		}
+	}
+	q.skipped[pick] = 0
+	entry := q.classes[pick][0]
+	q.classes[pick][0] = queueEntry{}
+	q.classes[pick] = q.classes[pick][1:]
+	<-q.space
+	wait := time.Since(entry.queued)
+	stats := &q.started[pick]
+	stats.Started++
+	stats.TotalWait += wait
+	if wait > stats.MaxWait {
+		stats.MaxWait = wait
+	}
+	return entry.cmd
+}
+
+// reject counts a command that did not fit in the queue.
+func (q *commandQueue) reject() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.rejected++
+}
+
+func (q *commandQueue) stats(now time.Time) QueueStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	res := QueueStats{Rejected: q.rejected, Priorities: q.started}
+	for prio, class := range q.classes {
+		res.Queued += len(class)
+		res.Priorities[prio].Queued = len(class)
+		if len(class) > 0 {
+			res.Priorities[prio].OldestWait = now.Sub(class[0].queued)
+		}
+	}
+	return res
+}
+
+// remove takes cmd out of the queue, it reports false if cmd was not queued.
+func (q *commandQueue) remove(cmd command) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for prio, class := range q.classes {
+		for i, x := range class {
+			if x.cmd == cmd {
+				q.classes[prio] = append(class[:i:i], class[i+1:]...)
+				<-q.space
+				return true
+			}
+		}
+	}
+	return false
+}
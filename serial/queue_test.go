@@ -0,0 +1,95 @@
+package serial
+
+import (
+	"context"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"testing"
+	"time"
+)
+
+func queued(q *commandQueue, prio Priority) command {
+	cmd := newRequestResponseCommand(&deviceStateRequest{}, &DeviceState{}, 0)
+	q.space <- struct{}{}
+	q.push(prio, cmd)
+	return cmd
+}
+
+func TestQueuePriority(t *testing.T) {
+	q := newCommandQueue(10)
+	bulk := queued(q, PriorityBulk)
+	polling := queued(q, PriorityPolling)
+	control := queued(q, PriorityControl)
+	for i, expected := range []command{control, polling, bulk, nil} {
+		if cmd := q.pop(); cmd != expected {
+			t.Fatal("pop", i, "returned the wrong command")
+		}
+	}
+}
+
+func TestQueueStarvation(t *testing.T) {
+	q := newCommandQueue(100)
+	bulk := queued(q, PriorityBulk)
+	for i := 0; i < starveAfter*2; i++ {
+		queued(q, PriorityControl)
+	}
+	for i := 0; i < starveAfter; i++ {
+		if q.pop() == bulk {
+			t.Fatal("bulk command started before it was starved")
+		}
+	}
+	if q.pop() != bulk {
+		t.Fatal("bulk command starved")
+	}
+}
+
+func TestQueueRemove(t *testing.T) {
+	q := newCommandQueue(1)
+	cmd := queued(q, PriorityControl)
+	if !q.remove(cmd) || q.remove(cmd) {
+		t.Fatal("expected the command to be removed once")
+	}
+	if q.pop() != nil || len(q.space) != 0 {
+		t.Fatal("removed command is still queued")
+	}
+}
+
+func TestWithPriority(t *testing.T) {
+	port, _ := newFakeStick(t, nil, nil, versionResponder)
+	if prio := port.priority(context.Background(), (&deviceStateRequest{}).CommandID()); prio != PriorityPolling {
+		t.Fatal("expected", PriorityPolling, "got", prio)
+	}
+	ctx := WithPriority(context.Background(), PriorityControl)
+	if prio := port.priority(ctx, (&SendDataRequest{}).CommandID()); prio != PriorityControl {
+		t.Fatal("expected", PriorityControl, "got", prio)
+	}
+}
+
+func TestNonBlocking(t *testing.T) {
+	port, _ := newFakeStick(t, NewOptions().SetQueueSize(1), nil, func(f frame.Frame) []frame.Frame { return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// One command in flight, one queued.
+	for i := 0; i < 2; i++ {
+		go port.GetDeviceStateContext(ctx)
+		for deadline := time.Now().Add(time.Second); ; {
+			if stats := port.QueueStats(); stats.InFlight+stats.Queued == i+1 {
+				break
+			} else if time.Now().After(deadline) {
+				t.Fatal("command not queued", stats)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if _, err := port.GetDeviceStateContext(WithNonBlocking(ctx)); err != ErrQueueFull {
+		t.Fatal("expected", ErrQueueFull, "got", err)
+	}
+	stats := port.QueueStats()
+	if stats.Rejected != 1 || stats.Queued != 1 || stats.InFlight != 1 || stats.Window != 1 {
+		t.Fatal("unexpected stats", stats)
+	}
+	polling := stats.Priorities[PriorityPolling]
+	if polling.Queued != 1 || polling.Started != 1 || polling.OldestWait <= 0 {
+		t.Fatal("unexpected polling stats", polling)
+	}
+}
@@ -0,0 +1,55 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"github.com/daedaluz/goconbee/serial/frame"
+)
+
+type rawRequest struct {
+	cmd     frame.Command
+	payload []byte
+}
+
+func (r *rawRequest) CommandID() frame.Command {
+	return r.cmd
+}
+
+func (r *rawRequest) encode(seqNumber uint8) frame.Frame {
+	return frame.NewFrame(r.cmd, seqNumber, r.payload)
+}
+
+type rawResponse struct {
+	cmd   frame.Command
+	frame frame.Frame
+}
+
+func (r *rawResponse) CommandID() frame.Command {
+	return r.cmd
+}
+
+func (r *rawResponse) decode(f frame.Frame) error {
+	r.frame = f
+	return nil
+}
+
+// withoutRetries turns off both retry policies, raw commands may not be safe to send twice.
+func withoutRetries(ctx context.Context) context.Context {
+	return WithBusyRetryPolicy(WithRetryPolicy(ctx, RetryPolicy{}), RetryPolicy{})
+}
+
+// SendRaw sends payload as cmd and returns the response as read from the stick, for
+// commands the package does not model. The response is also returned together with
+// the StatusError if the status is not frame.StatusSuccess. The command is sent once,
+// it is not retried when it times out or the firmware is busy.
+func (p *Port) SendRaw(ctx context.Context, cmd frame.Command, payload []byte) (frame.Frame, error) {
+	res := &rawResponse{cmd: cmd}
+	if err := p.execute(withoutRetries(ctx), &rawRequest{cmd: cmd, payload: payload}, res); err != nil {
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			return statusErr.Frame, err
+		}
+		return nil, err
+	}
+	return res.frame, nil
+}
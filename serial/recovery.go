@@ -0,0 +1,144 @@
+package serial
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	recoveryMinBackoff = time.Millisecond * 250
+	recoveryMaxBackoff = time.Second * 5
+)
+
+// RecoveryHandler initialises the stick again after the port has recovered from a disconnect,
+// e.g. by configuring the watchdog, endpoint slots or permit join.
+type RecoveryHandler func(p *Port) error
+
+// EnableRecovery makes the port survive a lost connection. Instead of closing, the port
+// reopens its device and sends the commands that were in flight again. Commands issued
+// while the device is gone are sent once it is available again, they still fail with
+// ErrTimeout if that takes longer than their command timeout.
+// init, if not nil, is run every time the port has recovered, before the port is StateReady
+// again and before the commands that were in flight are sent again.
+func (p *Port) EnableRecovery(init RecoveryHandler) error {
+	p.connLock.Lock()
+	_, reconnects := p.conn.(portAttacher)
+	p.connLock.Unlock()
+	if p.dial == nil && !reconnects {
+		return errors.New("transport can not be reopened")
+	}
+	p.onlineLock.Lock()
+	defer p.onlineLock.Unlock()
+	p.recoveryEnabled = true
+	p.recovery = init
+	return nil
+}
+
+func (p *Port) recoveryHandler() (bool, RecoveryHandler) {
+	p.onlineLock.Lock()
+	defer p.onlineLock.Unlock()
+	return p.recoveryEnabled, p.recovery
+}
+
+// recovering reports if commands that fail to be written should be sent again once the port is back online.
+func (p *Port) recovering() bool {
+	p.onlineLock.Lock()
+	defer p.onlineLock.Unlock()
+	if !p.recoveryEnabled {
+		return false
+	}
+	select {
+	case <-p.online:
+		return false
+	default:
+		return true
+	}
+}
+
+// recover is called by rx when reading failed. It reports whether the port
+// is being reopened in the background.
+func (p *Port) recover() bool {
+	if enabled, _ := p.recoveryHandler(); !enabled || p.dial == nil || p.closing.Load() {
+		return false
+	}
+	p.disconnected()
+	return p.spawn(p.reopen)
+}
+
+func (p *Port) reopen() {
+	backoff := recoveryMinBackoff
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-time.After(backoff):
+		}
+		conn, err := p.dial()
+		if err != nil {
+			backoff *= 2
+			if backoff > recoveryMaxBackoff {
+				backoff = recoveryMaxBackoff
+			}
+			continue
+		}
+		if !p.replaceConn(conn) {
+			return
+		}
+		rw := p.reader()
+		if p.spawn(func() { p.rx(rw) }) {
+			p.reconnected()
+		}
+		return
+	}
+}
+
+func (p *Port) disconnected() {
+	p.onlineLock.Lock()
+	select {
+	case <-p.online:
+		p.online = make(chan struct{})
+	default:
+	}
+	p.onlineLock.Unlock()
+	p.setState(StateReconnecting)
+	p.handlers.DisconnectHandler(p)
+}
+
+func (p *Port) reconnected() {
+	p.onlineLock.Lock()
+	select {
+	case <-p.online:
+	default:
+		close(p.online)
+	}
+	p.onlineLock.Unlock()
+
+	enabled, init := p.recoveryHandler()
+	if !enabled {
+		p.kickWatchdog()
+		p.kickDataPump()
+		p.setState(StateReady)
+		p.handlers.ReconnectHandler(p)
+		return
+	}
+	// The stick is initialised again before the port is ready and the commands that
+	// were in flight are sent again.
+	p.park()
+	p.spawn(func() {
+		if p.Firmware() != nil {
+			if err := p.Handshake(); err != nil {
+				p.log.Println("Conbee handshake failed:", err)
+			}
+		}
+		if init != nil {
+			if err := init(p); err != nil {
+				p.log.Println("Conbee recovery failed:", err)
+			}
+		}
+		p.setState(StateReady, StateReconnecting, StateHandshaking)
+		p.unpark()
+		p.kickWatchdog()
+		p.kickDataPump()
+		p.handlers.ReconnectHandler(p)
+	})
+}
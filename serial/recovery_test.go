@@ -0,0 +1,116 @@
+package serial
+
+import (
+	"errors"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRecovery(t *testing.T) {
+	dials := make(chan net.Conn, 2)
+	seen := make(chan frame.Command, 10)
+	dial := func() (io.ReadWriteCloser, error) {
+		local, remote := net.Pipe()
+		serveFake(remote, func(f frame.Frame) []frame.Frame {
+			seen <- f.CommandID()
+			if f.CommandID() == frame.CmdDeviceState {
+				return []frame.Frame{deviceStateResponse(f)}
+			}
+			return versionResponder(f)
+		})
+		dials <- remote
+		return local, nil
+	}
+
+	// The first connection drops the first request and disconnects.
+	local, remote := net.Pipe()
+	serveFake(remote, func(f frame.Frame) []frame.Frame {
+		remote.Close()
+		return nil
+	})
+	port := openTransport(local, &Handlers{
+		UnsolicitedHandler: func(p *Port, msg CommandID) {},
+		DisconnectHandler:  func(p *Port) {},
+	}, dial, *NewOptions())
+	defer port.Close()
+
+	inits := atomic.Int32{}
+	if err := port.EnableRecovery(func(p *Port) error {
+		inits.Add(1)
+		_, err := p.GetDeviceState()
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := port.ReadFirmwareVersion()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("command was not recovered")
+	}
+	defer (<-dials).Close()
+	// The recovery handler has run and the port is ready before the command is sent again.
+	if inits.Load() != 1 || port.State() != StateReady {
+		t.Fatal("expected recovery handler to run once before the resend, got", inits.Load(), port.State())
+	}
+	for _, expected := range []frame.Command{frame.CmdDeviceState, frame.CmdVersion} {
+		if cmd := <-seen; cmd != expected {
+			t.Fatal("expected", expected, "got", cmd)
+		}
+	}
+}
+
+func TestRecoveryTimeout(t *testing.T) {
+	dial := func() (io.ReadWriteCloser, error) {
+		return nil, errors.New("device gone")
+	}
+	local, remote := net.Pipe()
+	serveFake(remote, nil)
+	disconnected := make(chan struct{}, 1)
+	port := openTransport(local, &Handlers{
+		UnsolicitedHandler: func(p *Port, msg CommandID) {},
+		DisconnectHandler:  func(p *Port) { disconnected <- struct{}{} },
+	}, dial, *NewOptions().SetCommandTimeout(time.Millisecond * 100))
+	defer port.Close()
+	if err := port.EnableRecovery(nil); err != nil {
+		t.Fatal(err)
+	}
+	remote.Close()
+	<-disconnected
+
+	// Commands written while the device is gone time out, and free the window for the next.
+	for i := 0; i < 2; i++ {
+		done := make(chan error, 1)
+		go func() {
+			_, err := port.GetDeviceState()
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrTimeout) {
+				t.Fatal("expected", ErrTimeout, "got", err)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatal("command held back during recovery did not time out")
+		}
+	}
+}
+
+func TestRecoveryUnsupported(t *testing.T) {
+	port, _ := newFakeStick(t, nil, nil, versionResponder)
+	if err := port.EnableRecovery(nil); err == nil {
+		t.Fatal("expected recovery to be unsupported on a plain transport")
+	}
+}
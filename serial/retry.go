@@ -0,0 +1,125 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy sends commands that are safe to send twice, reading the version, parameters
+// and the device state, again when they time out. It is also used for commands the
+// firmware answers with frame.StatusBusy, which are retried whatever the command.
+type RetryPolicy struct {
+	// Attempts is how often a command is sent at most, 0 and 1 send it once.
+	Attempts int
+	// Backoff is the wait before the first retry, it doubles with every retry up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Jitter adds up to Jitter times the backoff at random, e.g. 0.2 adds up to 20%.
+	Jitter float64
+}
+
+// backoff returns the wait before the retry following attempt.
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := r.Backoff
+	for i := 1; i < attempt && (r.MaxBackoff <= 0 || backoff < r.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+		backoff = r.MaxBackoff
+	}
+	if r.Jitter > 0 {
+		backoff += time.Duration(rand.Float64() * r.Jitter * float64(backoff))
+	}
+	return backoff
+}
+
+var idempotentCommands = map[frame.Command]bool{
+	frame.CmdVersion:       true,
+	frame.CmdReadParameter: true,
+	frame.CmdDeviceState:   true,
+}
+
+type contextKey int
+
+const (
+	commandTimeoutKey = contextKey(iota)
+	retryPolicyKey
+	priorityKey
+	nonBlockingKey
+	busyRetryPolicyKey
+)
+
+// WithCommandTimeout overrides Options.CommandTimeout for the commands ctx is passed to.
+func WithCommandTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, commandTimeoutKey, timeout)
+}
+
+// WithRetryPolicy overrides Options.Retry for the commands ctx is passed to.
+func WithRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey, policy)
+}
+
+// WithBusyRetryPolicy overrides Options.BusyRetry for the commands ctx is passed to.
+func WithBusyRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, busyRetryPolicyKey, policy)
+}
+
+func (p *Port) commandTimeout(ctx context.Context) time.Duration {
+	if timeout, ok := ctx.Value(commandTimeoutKey).(time.Duration); ok && timeout > 0 {
+		return timeout
+	}
+	return p.options.CommandTimeout
+}
+
+func (p *Port) retryPolicy(ctx context.Context, cmd frame.Command) RetryPolicy {
+	if !idempotentCommands[cmd] {
+		return RetryPolicy{}
+	}
+	if policy, ok := ctx.Value(retryPolicyKey).(RetryPolicy); ok {
+		return policy
+	}
+	return p.options.Retry
+}
+
+func (p *Port) busyRetryPolicy(ctx context.Context, cmd frame.Command) RetryPolicy {
+	if policy, ok := ctx.Value(busyRetryPolicyKey).(RetryPolicy); ok {
+		return policy
+	}
+	if policy, ok := p.options.BusyRetryCommands[cmd]; ok {
+		return policy
+	}
+	return p.options.BusyRetry
+}
+
+// retries counts the attempts of one call, timeouts and busy answers are retried
+// independently, each up to the attempts of its policy.
+type retries struct {
+	timeout, busy         RetryPolicy
+	timeouts, busyAnswers int
+}
+
+func (p *Port) newRetries(ctx context.Context, cmd frame.Command) *retries {
+	return &retries{
+		timeout:     p.retryPolicy(ctx, cmd),
+		busy:        p.busyRetryPolicy(ctx, cmd),
+		timeouts:    1,
+		busyAnswers: 1,
+	}
+}
+
+// next returns the wait before the next attempt after an attempt failed with err,
+// or false if err is final.
+func (r *retries) next(err error) (time.Duration, bool) {
+	switch {
+	case errors.Is(err, ErrTimeout) && r.timeouts < r.timeout.Attempts:
+		r.timeouts++
+		return r.timeout.backoff(r.timeouts - 1), true
+	case errors.Is(err, frame.StatusBusy) && r.busyAnswers < r.busy.Attempts:
+		r.busyAnswers++
+		return r.busy.backoff(r.busyAnswers - 1), true
+	}
+	return 0, false
+}
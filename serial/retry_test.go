@@ -0,0 +1,104 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCommandTimeoutOverride(t *testing.T) {
+	// Without a read timeout rx never wakes up, the timeout has to fire on its own.
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame { return nil })
+	start := time.Now()
+	ctx := WithCommandTimeout(context.Background(), time.Millisecond*50)
+	if _, err := port.GetDeviceStateContext(ctx); !errors.Is(err, ErrTimeout) {
+		t.Fatal("expected", ErrTimeout, "got", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("timeout override not applied")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var versions, sends atomic.Int32
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+		switch f.CommandID() {
+		case frame.CmdVersion:
+			// Only the third attempt is answered.
+			if versions.Add(1) < 3 {
+				return nil
+			}
+			return versionResponder(f)
+		case frame.CmdAPSDataRequest:
+			sends.Add(1)
+		}
+		return nil
+	})
+	ctx := WithCommandTimeout(context.Background(), time.Millisecond*50)
+	ctx = WithRetryPolicy(ctx, RetryPolicy{Attempts: 3, Backoff: time.Millisecond * 10, Jitter: 0.5})
+	if _, err := port.ReadFirmwareVersionContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if versions.Load() != 3 {
+		t.Fatal("expected 3 attempts, got", versions.Load())
+	}
+	if _, err := port.SendDataContext(ctx, 1, Address{Mode: AddressNWK, Short: 0x1234, Endpoint: 1}, 0x0104, 0x0006, 1, nil, 0, 0); !errors.Is(err, ErrTimeout) {
+		t.Fatal("expected", ErrTimeout, "got", err)
+	}
+	if sends.Load() != 1 {
+		t.Fatal("send data was retried")
+	}
+}
+
+func TestBusyRetry(t *testing.T) {
+	var sends, states atomic.Int32
+	busy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+	opts := NewOptions().SetBusyRetry(busy).SetCommandBusyRetry(frame.CmdDeviceState, RetryPolicy{Attempts: 2, Backoff: time.Millisecond})
+	port, _ := newFakeStick(t, opts, nil, func(f frame.Frame) []frame.Frame {
+		switch f.CommandID() {
+		case frame.CmdAPSDataRequest:
+			// The firmware is busy twice before it accepts the request.
+			if sends.Add(1) < 3 {
+				return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusBusy, nil)}
+			}
+			return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusSuccess, []byte{0, 0, 0x22, 1})}
+		case frame.CmdDeviceState:
+			states.Add(1)
+			return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusBusy, nil)}
+		}
+		return nil
+	})
+
+	res, err := port.SendData(1, Address{Mode: AddressNWK, Short: 0x1234, Endpoint: 1}, 0x0104, 0x0006, 1, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RequestID != 1 || sends.Load() != 3 {
+		t.Fatal("expected 3 attempts, got", sends.Load())
+	}
+	_, err = port.GetDeviceState()
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != frame.StatusBusy {
+		t.Fatal("expected", frame.StatusBusy, "got", err)
+	}
+	if states.Load() != 2 {
+		t.Fatal("expected 2 attempts, got", states.Load())
+	}
+	states.Store(0)
+	ctx := WithBusyRetryPolicy(context.Background(), RetryPolicy{Attempts: 1})
+	if _, err := port.GetDeviceStateContext(ctx); !errors.Is(err, frame.StatusBusy) || states.Load() != 1 {
+		t.Fatal("busy retry policy not overridden", err, states.Load())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{Backoff: time.Millisecond * 100, MaxBackoff: time.Millisecond * 300}
+	for attempt, expected := range []time.Duration{100, 200, 300, 300} {
+		if backoff := policy.backoff(attempt + 1); backoff != expected*time.Millisecond {
+			t.Fatal("attempt", attempt+1, "expected", expected*time.Millisecond, "got", backoff)
+		}
+	}
+}
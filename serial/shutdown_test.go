@@ -0,0 +1,79 @@
+package serial
+
+import (
+	"github.com/daedaluz/goconbee/serial/frame"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// portGoroutines returns the stacks of goroutines that belong to a Port.
+func portGoroutines() []string {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	var res []string
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		for _, fn := range []string{"(*Port).rx", "(*Port).dispatch", "(*Port).keepWatchdog", "(*Port).reopen", "(*Port).runDataPump"} {
+			if strings.Contains(stack, fn) {
+				res = append(res, stack)
+				break
+			}
+		}
+	}
+	return res
+}
+
+func TestClose(t *testing.T) {
+	received := make(chan frame.Frame, 10)
+	port, _ := newFakeStick(t, NewOptions().SetCommandHandlers(2), nil, func(f frame.Frame) []frame.Frame {
+		if f.CommandID() == frame.CmdWriteParameter {
+			return []frame.Frame{statusFrame(frame.CmdWriteParameter, f.SeqNumber(), frame.StatusSuccess, []byte{1, 0, f.Data()[2]})}
+		}
+		received <- f
+		return nil
+	})
+	if err := port.StartWatchdog(time.Minute, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	results := make(chan error, 5)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			_, err := port.GetDeviceState()
+			results <- err
+		}()
+	}
+	// Two commands in flight, the rest queued.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatal("commands were not sent")
+		}
+	}
+	// The data pump waits for its device state read in the queue.
+	if _, err := port.StartDataPump(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	port.Close()
+	if leaked := portGoroutines(); len(leaked) > 0 {
+		t.Fatal("goroutines still running after Close:\n", strings.Join(leaked, "\n\n"))
+	}
+	for i := 0; i < cap(results); i++ {
+		select {
+		case err := <-results:
+			if err != ErrClosed {
+				t.Fatal("expected ErrClosed, got", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("command still blocked after Close")
+		}
+	}
+	if err := port.Close(); err != nil {
+		t.Fatal("second close failed:", err)
+	}
+	if _, err := port.GetDeviceState(); err != ErrClosed {
+		t.Fatal("expected ErrClosed after close, got", err)
+	}
+}
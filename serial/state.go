@@ -0,0 +1,91 @@
+package serial
+
+// State is the connection state of a Port.
+type State uint8
+
+const (
+	// The port is being set up.
+	StateOpening = State(iota)
+	// The firmware and protocol version are being read.
+	StateHandshaking
+	// The stick answers commands.
+	StateReady
+	// The stick is connected but commands time out or the watchdog could not be refreshed.
+	StateDegraded
+	// The connection was lost and the port is trying to get it back.
+	StateReconnecting
+	// The port has been closed for good.
+	StateClosed
+)
+
+// StateHandler is called on every state change of the port. Calls don't overlap and come in
+// the order of the changes, a change made while the handler runs is reported once it returns.
+// It must not call Close, the change to StateClosed is reported while Close is shutting the
+// port down and would deadlock.
+type StateHandler func(p *Port, old, new State)
+
+type stateChange struct {
+	old, new State
+}
+
+// Consecutive command timeouts before a port is considered degraded.
+const degradedAfter = 3
+
+func (p *Port) State() State {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	return p.state
+}
+
+// setState changes the state to new if the current state is one of from, or any state but
+// StateClosed if from is empty. It reports whether the state was changed.
+func (p *Port) setState(new State, from ...State) bool {
+	p.stateLock.Lock()
+	old := p.state
+	allowed := old != StateClosed && old != new
+	if allowed && len(from) > 0 {
+		allowed = false
+		for _, state := range from {
+			allowed = allowed || state == old
+		}
+	}
+	if allowed {
+		p.state = new
+		p.stateChanges = append(p.stateChanges, stateChange{old: old, new: new})
+	}
+	p.stateLock.Unlock()
+	if allowed {
+		p.deliverStates()
+	}
+	return allowed
+}
+
+// deliverStates reports the queued state changes to the StateHandler, unless another
+// goroutine is doing so already, which then reports them as well.
+func (p *Port) deliverStates() {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	if p.delivering {
+		return
+	}
+	p.delivering = true
+	for len(p.stateChanges) > 0 {
+		change := p.stateChanges[0]
+		p.stateChanges = p.stateChanges[1:]
+		p.stateLock.Unlock()
+		p.handlers.StateHandler(p, change.old, change.new)
+		p.stateLock.Lock()
+	}
+	p.delivering = false
+}
+
+func (p *Port) commandSucceeded() {
+	p.timeouts.Store(0)
+	p.setState(StateReady, StateDegraded)
+}
+
+func (p *Port) commandTimedOut() {
+	if p.timeouts.Add(1) >= degradedAfter {
+		p.setState(StateDegraded, StateReady)
+	}
+}
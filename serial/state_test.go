@@ -0,0 +1,106 @@
+package serial
+
+import (
+	"github.com/daedaluz/goconbee/serial/frame"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStateChanges(t *testing.T) {
+	lock := sync.Mutex{}
+	var states []State
+	answer := true
+	port, _ := newFakeStick(t, NewOptions().SetReadTimeout(time.Millisecond*50).SetCommandTimeout(time.Millisecond*50), &Handlers{
+		StateHandler: func(p *Port, old, new State) {
+			lock.Lock()
+			defer lock.Unlock()
+			states = append(states, new)
+		},
+	}, func(f frame.Frame) []frame.Frame {
+		lock.Lock()
+		defer lock.Unlock()
+		if !answer {
+			return nil
+		}
+		return []frame.Frame{statusFrame(f.CommandID(), f.SeqNumber(), frame.StatusSuccess, []byte{0, 0, 0})}
+	})
+	if port.State() != StateReady {
+		t.Fatal("expected port to be ready, got", port.State())
+	}
+
+	lock.Lock()
+	answer = false
+	lock.Unlock()
+	for i := 0; i < degradedAfter; i++ {
+		if _, err := port.GetDeviceState(); err == nil {
+			t.Fatal("expected timeout")
+		}
+	}
+	if port.State() != StateDegraded {
+		t.Fatal("expected port to be degraded, got", port.State())
+	}
+
+	lock.Lock()
+	answer = true
+	lock.Unlock()
+	if _, err := port.GetDeviceState(); err != nil {
+		t.Fatal(err)
+	}
+	port.Close()
+
+	lock.Lock()
+	defer lock.Unlock()
+	expected := []State{StateReady, StateDegraded, StateReady, StateClosed}
+	if !reflect.DeepEqual(states, expected) {
+		t.Fatal("expected", expected, "got", states)
+	}
+}
+
+func TestStateChangesOrdered(t *testing.T) {
+	lock := sync.Mutex{}
+	var changes [][2]State
+	running := atomic.Int32{}
+	port, _ := newFakeStick(t, nil, &Handlers{
+		StateHandler: func(p *Port, old, new State) {
+			if running.Add(1) != 1 {
+				t.Error("state handler calls overlap")
+			}
+			// Give racing changes a chance to overtake this one.
+			time.Sleep(time.Microsecond * 10)
+			lock.Lock()
+			changes = append(changes, [2]State{old, new})
+			lock.Unlock()
+			running.Add(-1)
+		},
+	}, func(f frame.Frame) []frame.Frame { return nil })
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				if i%2 == 0 {
+					port.commandTimedOut()
+				} else {
+					port.commandSucceeded()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	port.Close()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(changes) < 2 || changes[0] != [2]State{StateOpening, StateReady} || changes[len(changes)-1][1] != StateClosed {
+		t.Fatal("unexpected state changes", changes)
+	}
+	for i := 1; i < len(changes); i++ {
+		if changes[i][0] != changes[i-1][1] {
+			t.Fatal("state change", i, "from", changes[i][0], "follows a change to", changes[i-1][1])
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package serial
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// tcpTransport connects to a stick exposed over the network, e.g. by ser2net in raw mode.
+// When the connection drops it keeps redialing with an exponential backoff until it
+// succeeds or the transport is closed.
+type tcpTransport struct {
+	addr        string
+	dial        func(ctx context.Context, network, addr string) (net.Conn, error)
+	readTimeout time.Duration
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+
+	lock      sync.Mutex
+	conn      net.Conn
+	port      *Port
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// dialTimeout bounds a single connection attempt, an unreachable host otherwise blocks
+// until the operating system gives up.
+const dialTimeout = time.Second * 10
+
+func dialTCP(addr string, readTimeout time.Duration) (*tcpTransport, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpTransport{
+		addr:        addr,
+		dial:        dialer.DialContext,
+		readTimeout: readTimeout,
+		minBackoff:  time.Millisecond * 500,
+		maxBackoff:  time.Second * 30,
+		conn:        conn,
+		closed:      make(chan struct{}),
+	}, nil
+}
+
+func (t *tcpTransport) attach(p *Port) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.port = p
+}
+
+func (t *tcpTransport) current() (net.Conn, *Port) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.conn, t.port
+}
+
+func (t *tcpTransport) Read(b []byte) (int, error) {
+	for {
+		conn, port := t.current()
+		if t.readTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(t.readTimeout))
+		}
+		n, err := conn.Read(b)
+		if err == nil || isTimeout(err) {
+			return n, err
+		}
+		if t.isClosed() {
+			return n, err
+		}
+		conn.Close()
+		if port != nil {
+			port.disconnected()
+		}
+		if !t.redial() {
+			return n, err
+		}
+		if port != nil {
+			port.reconnected()
+		}
+	}
+}
+
+func (t *tcpTransport) redial() bool {
+	// Close cancels a dial in progress.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-t.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	t.lock.Lock()
+	dial, backoff := t.dial, t.minBackoff
+	t.lock.Unlock()
+	for {
+		select {
+		case <-t.closed:
+			return false
+		case <-time.After(backoff):
+		}
+		conn, err := dial(ctx, "tcp", t.addr)
+		if err == nil {
+			t.lock.Lock()
+			if t.isClosed() {
+				t.lock.Unlock()
+				conn.Close()
+				return false
+			}
+			t.conn = conn
+			t.lock.Unlock()
+			return true
+		}
+		backoff *= 2
+		if backoff > t.maxBackoff {
+			backoff = t.maxBackoff
+		}
+	}
+}
+
+// Write fails while the connection is down, the reader takes care of reconnecting.
+func (t *tcpTransport) Write(b []byte) (int, error) {
+	conn, _ := t.current()
+	n, err := conn.Write(b)
+	if err != nil {
+		conn.Close()
+	}
+	return n, err
+}
+
+func (t *tcpTransport) isClosed() bool {
+	select {
+	case <-t.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *tcpTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		t.lock.Lock()
+		err = t.conn.Close()
+		t.lock.Unlock()
+	})
+	return err
+}
@@ -0,0 +1,105 @@
+package serial
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			serveFake(conn, versionResponder)
+			conns <- conn
+		}
+	}()
+
+	disconnected := make(chan bool, 1)
+	reconnected := make(chan bool, 1)
+	port, err := Open("tcp://"+l.Addr().String(), &Handlers{
+		DisconnectHandler: func(p *Port) { disconnected <- true },
+		ReconnectHandler:  func(p *Port) { reconnected <- true },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+	port.conn.(*tcpTransport).minBackoff = time.Millisecond * 10
+
+	if _, err := port.ReadFirmwareVersion(); err != nil {
+		t.Fatal(err)
+	}
+	(<-conns).Close()
+	for _, ch := range []chan bool{disconnected, reconnected} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second * 5):
+			t.Fatal("no disconnect/reconnect reported")
+		}
+	}
+	defer (<-conns).Close()
+	if _, err := port.ReadFirmwareVersion(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTCPCloseWhileRedialing(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		serveFake(conn, versionResponder)
+		conns <- conn
+	}()
+
+	port, err := Open("tcp://"+l.Addr().String(), &Handlers{DisconnectHandler: func(p *Port) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := port.conn.(*tcpTransport)
+	dialing := make(chan struct{}, 1)
+	transport.lock.Lock()
+	transport.minBackoff = time.Millisecond
+	// A blackholed host, the dial only returns when it is canceled.
+	transport.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialing <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	transport.lock.Unlock()
+
+	(<-conns).Close()
+	select {
+	case <-dialing:
+	case <-time.After(time.Second * 5):
+		t.Fatal("no redial")
+	}
+	closed := make(chan struct{})
+	go func() {
+		port.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 2):
+		t.Fatal("Close blocked by the redial")
+	}
+}
@@ -0,0 +1,129 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// WatchdogHandler is called when refreshing the firmware watchdog failed, err wraps the
+// error of the refresh.
+type WatchdogHandler func(p *Port, err error)
+
+type watchdog struct {
+	ttl      time.Duration
+	interval time.Duration
+	kick     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	// handling is set while Handlers.WatchdogHandler runs.
+	handling atomic.Bool
+}
+
+// StartWatchdog sets the firmware watchdog to ttl and keeps refreshing it every interval
+// until StopWatchdog or Close is called. The watchdog is refreshed right away after the
+// port has reconnected. Failed refreshes are reported to Handlers.WatchdogHandler.
+func (p *Port) StartWatchdog(ttl, interval time.Duration) error {
+	if err := p.checkParameter(ParamWatchdogTTL); err != nil {
+		return err
+	}
+	if ttl < time.Second || interval <= 0 || interval >= ttl {
+		return errors.New("watchdog interval must be shorter than the ttl")
+	}
+	p.StopWatchdog()
+	if err := p.WriteParameter(ParamWatchdogTTL, uint32(ttl/time.Second)); err != nil {
+		return err
+	}
+	w := &watchdog{
+		ttl:      ttl,
+		interval: interval,
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	p.watchdogLock.Lock()
+	defer p.watchdogLock.Unlock()
+	if !p.spawn(func() { p.keepWatchdog(w) }) {
+		return ErrClosed
+	}
+	p.watchdog = w
+	return nil
+}
+
+// StopWatchdog stops refreshing the watchdog. The firmware will reboot when the ttl runs out,
+// unless the watchdog is refreshed by other means. A refresh that is in flight is canceled.
+// It waits for the watchdog to stop, unless
+// the WatchdogHandler is running, which may call StopWatchdog itself. The watchdog stops
+// once the handler has returned then.
+func (p *Port) StopWatchdog() {
+	if w := p.detachWatchdog(); w != nil && !w.handling.Load() {
+		<-w.done
+	}
+}
+
+// detachWatchdog tells the running watchdog, if any, to stop and returns it.
+func (p *Port) detachWatchdog() *watchdog {
+	p.watchdogLock.Lock()
+	defer p.watchdogLock.Unlock()
+	w := p.watchdog
+	p.watchdog = nil
+	if w != nil {
+		close(w.stop)
+	}
+	return w
+}
+
+// kickWatchdog makes the watchdog refresh right away, e.g. after a reconnect.
+func (p *Port) kickWatchdog() {
+	p.watchdogLock.Lock()
+	defer p.watchdogLock.Unlock()
+	if p.watchdog == nil {
+		return
+	}
+	select {
+	case p.watchdog.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Port) keepWatchdog(w *watchdog) {
+	defer close(w.done)
+	// Refreshes are canceled by StopWatchdog instead of running into their timeouts.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-w.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		// A stop wins over a tick that is due at the same time.
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		case <-w.kick:
+		}
+		if err := p.WriteParameterContext(ctx, ParamWatchdogTTL, uint32(w.ttl/time.Second)); err != nil {
+			if ctx.Err() != nil || p.closing.Load() {
+				// Stopped or closed while refreshing, not a failure of the stick.
+				return
+			}
+			p.setState(StateDegraded, StateReady)
+			w.handling.Store(true)
+			p.handlers.WatchdogHandler(p, fmt.Errorf("watchdog refresh: %w", err))
+			w.handling.Store(false)
+		}
+	}
+}
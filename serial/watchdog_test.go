@@ -0,0 +1,123 @@
+package serial
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	refreshes := atomic.Int32{}
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+		data := f.Data()
+		if f.CommandID() != frame.CmdWriteParameter || ParameterID(data[2]) != ParamWatchdogTTL {
+			return nil
+		}
+		if binary.LittleEndian.Uint32(data[3:]) != 2 {
+			t.Error("unexpected ttl", data[3:])
+		}
+		status := frame.StatusSuccess
+		if refreshes.Add(1) > 3 {
+			status = frame.StatusError
+		}
+		return []frame.Frame{statusFrame(frame.CmdWriteParameter, f.SeqNumber(), status, []byte{1, 0, data[2]})}
+	})
+	failures := make(chan error, 10)
+	port.handlers.WatchdogHandler = func(p *Port, err error) {
+		failures <- err
+	}
+	if err := port.StartWatchdog(time.Second*2, time.Millisecond*20); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-failures:
+		if !errors.Is(err, frame.StatusError) {
+			t.Fatal("unexpected failure", err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("watchdog failure not reported")
+	}
+	port.StopWatchdog()
+	n := refreshes.Load()
+	time.Sleep(time.Millisecond * 100)
+	if refreshes.Load() != n {
+		t.Fatal("watchdog still running after stop")
+	}
+}
+
+func TestWatchdogStopFromHandler(t *testing.T) {
+	failing := atomic.Bool{}
+	port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+		if f.CommandID() != frame.CmdWriteParameter {
+			return nil
+		}
+		status := frame.StatusSuccess
+		if failing.Load() {
+			status = frame.StatusError
+		}
+		return []frame.Frame{statusFrame(frame.CmdWriteParameter, f.SeqNumber(), status, []byte{1, 0, f.Data()[2]})}
+	})
+	stopped := make(chan error, 1)
+	port.handlers.WatchdogHandler = func(p *Port, err error) {
+		p.StopWatchdog()
+		stopped <- err
+	}
+	if err := port.StartWatchdog(time.Second*2, time.Millisecond*20); err != nil {
+		t.Fatal(err)
+	}
+	failing.Store(true)
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, frame.StatusError) {
+			t.Fatal("unexpected failure", err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("StopWatchdog from the handler did not return")
+	}
+	port.StopWatchdog()
+}
+
+func TestWatchdogStopWhileRefreshing(t *testing.T) {
+	for _, stop := range []func(p *Port){(*Port).StopWatchdog, func(p *Port) { p.Close() }} {
+		refreshing := make(chan struct{}, 1)
+		refreshes := atomic.Int32{}
+		port, _ := newFakeStick(t, nil, nil, func(f frame.Frame) []frame.Frame {
+			if f.CommandID() != frame.CmdWriteParameter {
+				return nil
+			}
+			if refreshes.Add(1) > 1 {
+				// The stick stops answering, the refresh would take the command timeout.
+				select {
+				case refreshing <- struct{}{}:
+				default:
+				}
+				return nil
+			}
+			return []frame.Frame{statusFrame(frame.CmdWriteParameter, f.SeqNumber(), frame.StatusSuccess, []byte{1, 0, f.Data()[2]})}
+		})
+		failures := atomic.Int32{}
+		port.handlers.WatchdogHandler = func(p *Port, err error) {
+			failures.Add(1)
+		}
+		if err := port.StartWatchdog(time.Second*2, time.Millisecond*20); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-refreshing:
+		case <-time.After(time.Second * 2):
+			t.Fatal("watchdog was not refreshed")
+		}
+		start := time.Now()
+		stop(port)
+		port.StopWatchdog()
+		if time.Since(start) > time.Second {
+			t.Fatal("stopping waited for the refresh to time out", time.Since(start))
+		}
+		if failures.Load() != 0 {
+			t.Fatal("stopping was reported as a watchdog failure")
+		}
+	}
+}
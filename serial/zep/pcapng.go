@@ -0,0 +1,121 @@
+package zep
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	blockSectionHeader        = 0x0a0d0d0a
+	blockInterfaceDescription = 0x00000001
+	blockEnhancedPacket       = 0x00000006
+
+	byteOrderMagic = 0x1a2b3c4d
+	// linkTypeIPv4 is LINKTYPE_IPV4, raw IPv4 packets without a link layer.
+	linkTypeIPv4 = 228
+)
+
+// PcapngWriter writes ZEP packets to a pcapng file, wrapped in IPv4 and UDP from and to
+// 127.0.0.1:17754. Timestamps have microsecond resolution.
+type PcapngWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewPcapngWriter writes the section header and interface description to w.
+func NewPcapngWriter(w io.Writer) (*PcapngWriter, error) {
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1) // major
+	binary.LittleEndian.PutUint16(shb[6:], 0) // minor
+	binary.LittleEndian.PutUint64(shb[8:], 0xffffffffffffffff)
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], linkTypeIPv4)
+	// reserved and snap length 0, no limit
+	if _, err := w.Write(append(block(blockSectionHeader, shb), block(blockInterfaceDescription, idb)...)); err != nil {
+		return nil, err
+	}
+	return &PcapngWriter{w: w}, nil
+}
+
+// CreatePcapng creates or truncates the file name and writes a pcapng header to it.
+func CreatePcapng(name string) (*PcapngWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewPcapngWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *PcapngWriter) WritePacket(t time.Time, packet []byte) error {
+	ip := udp4(packet)
+	epb := make([]byte, 20, 20+len(ip)+3)
+	ts := uint64(t.UnixMicro())
+	binary.LittleEndian.PutUint32(epb[0:], 0) // interface
+	binary.LittleEndian.PutUint32(epb[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(epb[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(epb[12:], uint32(len(ip)))
+	binary.LittleEndian.PutUint32(epb[16:], uint32(len(ip)))
+	epb = append(epb, ip...)
+	for len(epb)%4 != 0 {
+		epb = append(epb, 0)
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	_, err := w.w.Write(block(blockEnhancedPacket, epb))
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (w *PcapngWriter) Close() error {
+	if c, ok := w.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// block frames body, which must be padded to 32 bits, as a pcapng block.
+func block(typ uint32, body []byte) []byte {
+	length := uint32(12 + len(body))
+	b := make([]byte, 0, length)
+	b = binary.LittleEndian.AppendUint32(b, typ)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	b = append(b, body...)
+	return binary.LittleEndian.AppendUint32(b, length)
+}
+
+// udp4 wraps payload in an IPv4 and UDP header from and to 127.0.0.1:17754.
+func udp4(payload []byte) []byte {
+	b := make([]byte, 28, 28+len(payload))
+	b[0] = 0x45 // version 4, 20 byte header
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)+len(payload)))
+	b[8] = 64 // ttl
+	b[9] = 17 // udp
+	copy(b[12:], []byte{127, 0, 0, 1})
+	copy(b[16:], []byte{127, 0, 0, 1})
+	binary.BigEndian.PutUint16(b[10:], ipChecksum(b[:20]))
+	binary.BigEndian.PutUint16(b[20:], Port)
+	binary.BigEndian.PutUint16(b[22:], Port)
+	binary.BigEndian.PutUint16(b[24:], uint16(8+len(payload)))
+	// udp checksum 0, not computed
+	return append(b, payload...)
+}
+
+func ipChecksum(h []byte) uint16 {
+	sum := uint32(0)
+	for i := 0; i < len(h); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(h[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,39 @@
+package zep
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// UDPWriter sends ZEP packets as udp datagrams, e.g. to a Wireshark capturing on loopback.
+type UDPWriter struct {
+	conn net.Conn
+}
+
+// DialUDP sends packets to addr, or to 127.0.0.1:17754 if addr is empty.
+func DialUDP(addr string) (*UDPWriter, error) {
+	if addr == "" {
+		addr = fmt.Sprintf("127.0.0.1:%d", Port)
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPWriter{conn: conn}, nil
+}
+
+func (w *UDPWriter) WritePacket(t time.Time, packet []byte) error {
+	_, err := w.conn.Write(packet)
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		// Nothing listens on the port, which is normal when Wireshark captures on loopback.
+		return nil
+	}
+	return err
+}
+
+func (w *UDPWriter) Close() error {
+	return w.conn.Close()
+}
@@ -0,0 +1,228 @@
+// Package zep exports the APS traffic of a serial.Port as ZigBee Encapsulation Protocol
+// packets for Wireshark, either to a pcapng file or as a live UDP stream.
+//
+// The stick only reports the APS layer, the 802.15.4 MAC and NWK headers around it are
+// made up from the addresses of the APS frame, so only the APS layer and above (ZDP, ZCL)
+// shows real values. Wireshark decodes ZEP on udp port 17754 by default.
+package zep
+
+import (
+	"encoding/binary"
+	"github.com/daedaluz/goconbee/serial"
+	"github.com/daedaluz/goconbee/serial/frame"
+	"sync"
+	"time"
+)
+
+// Port is the udp port Wireshark expects ZEP on.
+const Port = 17754
+
+const (
+	// CoordinatorAddress is the nwk address of the stick.
+	CoordinatorAddress = 0x0000
+	// unknownAddress is used when the stick only reported an IEEE address.
+	unknownAddress   = 0xfffe
+	broadcastAddress = 0xffff
+	// groupAddress is the nwk destination of group addressed frames, all rx-on-when-idle devices.
+	groupAddress = 0xfffd
+)
+
+// Writer writes ZEP packets, see PcapngWriter and UDPWriter.
+type Writer interface {
+	WritePacket(t time.Time, packet []byte) error
+	Close() error
+}
+
+// Exporter turns ApsData indications and SendData requests into ZEP packets.
+type Exporter struct {
+	// Channel and PANID of the network, written to the ZEP and MAC headers.
+	Channel uint8
+	PANID   uint16
+	// DeviceID identifies the stick in the ZEP header.
+	DeviceID uint16
+
+	lock       sync.Mutex
+	w          Writer
+	seq        uint32
+	macSeq     uint8
+	nwkSeq     uint8
+	apsCounter uint8
+	err        error
+}
+
+func NewExporter(w Writer, channel uint8, panID uint16) *Exporter {
+	return &Exporter{
+		Channel: channel,
+		PANID:   panID,
+		w:       w,
+	}
+}
+
+// Indication exports data received by the stick.
+func (e *Exporter) Indication(t time.Time, ind *serial.ApsData) error {
+	return e.export(t, ind.LQI, apsFrame{
+		src:     nwkAddress(ind.SrcAddress, unknownAddress),
+		dst:     nwkAddress(ind.DstAddress, CoordinatorAddress),
+		group:   ind.DstAddress.Mode == serial.AddressGroup,
+		srcEP:   ind.SrcAddress.Endpoint,
+		dstEP:   ind.DstAddress.Endpoint,
+		profile: ind.ProfileID,
+		cluster: ind.ClusterID,
+		payload: ind.Data,
+	})
+}
+
+// Request exports data sent by the stick.
+func (e *Exporter) Request(t time.Time, req *serial.SendDataRequest) error {
+	return e.export(t, 0xff, apsFrame{
+		src:     CoordinatorAddress,
+		dst:     nwkAddress(req.DstAddress, unknownAddress),
+		group:   req.DstAddress.Mode == serial.AddressGroup,
+		srcEP:   req.SrcEP,
+		dstEP:   req.DstAddress.Endpoint,
+		profile: req.ProfileID,
+		cluster: req.ClusterID,
+		payload: req.Data,
+	})
+}
+
+// Interceptor exports the data requests written to and the data indications read from
+// the stick, add it to a port with
+//
+//	remove := port.AddInterceptor(exporter.Interceptor())
+//
+// Write errors stop the export, they are returned by Err.
+func (e *Exporter) Interceptor() serial.Interceptor {
+	return func(p *serial.Port, ev *serial.FrameEvent) bool {
+		if !ev.CRCValid {
+			return true
+		}
+		switch {
+		case ev.Direction == serial.DirectionTX && ev.Frame.CommandID() == frame.CmdAPSDataRequest:
+			if req, err := serial.DecodeSendDataRequest(ev.Frame); err == nil {
+				e.Request(ev.Time, req)
+			}
+		case ev.Direction == serial.DirectionRX && ev.Frame.CommandID() == frame.CmdAPSDataIndication:
+			if ind, err := serial.DecodeApsData(ev.Frame); err == nil {
+				e.Indication(ev.Time, ind)
+			}
+		}
+		return true
+	}
+}
+
+func (e *Exporter) Err() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.err
+}
+
+// Close closes the underlying Writer.
+func (e *Exporter) Close() error {
+	return e.w.Close()
+}
+
+func (e *Exporter) export(t time.Time, lqi uint8, f apsFrame) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.err != nil {
+		return e.err
+	}
+	e.seq++
+	e.macSeq++
+	e.nwkSeq++
+	e.apsCounter++
+	mac := f.mac(e.PANID, e.macSeq, e.nwkSeq, e.apsCounter)
+	packet := append(header(e.Channel, e.DeviceID, lqi, t, e.seq, len(mac)), mac...)
+	e.err = e.w.WritePacket(t, packet)
+	return e.err
+}
+
+func nwkAddress(a serial.Address, fallback uint16) uint16 {
+	switch a.Mode {
+	case serial.AddressGroup, serial.AddressNWK, serial.AddressNWKAndIEEE:
+		return a.Short
+	}
+	return fallback
+}
+
+// ntpEpoch is the unix time of the NTP epoch, 1900-01-01.
+const ntpEpoch = -2208988800
+
+// header returns a ZEP v2 data header for a 802.15.4 frame of length bytes.
+func header(channel uint8, deviceID uint16, lqi uint8, t time.Time, seq uint32, length int) []byte {
+	h := make([]byte, 32)
+	h[0], h[1] = 'E', 'X'
+	h[2] = 2 // version
+	h[3] = 1 // data
+	h[4] = channel
+	binary.BigEndian.PutUint16(h[5:], deviceID)
+	h[7] = 1 // CRC mode, the frame ends with a real FCS
+	h[8] = lqi
+	binary.BigEndian.PutUint32(h[9:], uint32(t.Unix()-ntpEpoch))
+	binary.BigEndian.PutUint32(h[13:], uint32((uint64(t.Nanosecond())<<32)/uint64(time.Second)))
+	binary.BigEndian.PutUint32(h[17:], seq)
+	// 10 reserved bytes
+	h[31] = uint8(length)
+	return h
+}
+
+type apsFrame struct {
+	src, dst     uint16
+	group        bool
+	srcEP, dstEP uint8
+	profile      uint16
+	cluster      uint16
+	payload      []byte
+}
+
+// mac returns f wrapped in a NWK and a 802.15.4 data frame, including the FCS.
+func (f apsFrame) mac(panID uint16, macSeq, nwkSeq, apsCounter uint8) []byte {
+	nwkDst, macDst := f.dst, f.dst
+	if f.group {
+		nwkDst = groupAddress
+	}
+	if nwkDst >= 0xfff8 {
+		macDst = broadcastAddress
+	}
+	b := make([]byte, 0, 32+len(f.payload))
+	// MAC: data frame, PAN id compression, short destination and source addresses
+	b = binary.LittleEndian.AppendUint16(b, 0x8841)
+	b = append(b, macSeq)
+	b = binary.LittleEndian.AppendUint16(b, panID)
+	b = binary.LittleEndian.AppendUint16(b, macDst)
+	b = binary.LittleEndian.AppendUint16(b, f.src)
+	// NWK: data frame, protocol version 2
+	b = binary.LittleEndian.AppendUint16(b, 0x0008)
+	b = binary.LittleEndian.AppendUint16(b, nwkDst)
+	b = binary.LittleEndian.AppendUint16(b, f.src)
+	b = append(b, 30, nwkSeq)
+	// APS: data frame, unicast or group delivery
+	if f.group {
+		b = append(b, 0x0c)
+		b = binary.LittleEndian.AppendUint16(b, f.dst)
+	} else {
+		b = append(b, 0x00, f.dstEP)
+	}
+	b = binary.LittleEndian.AppendUint16(b, f.cluster)
+	b = binary.LittleEndian.AppendUint16(b, f.profile)
+	b = append(b, f.srcEP, apsCounter)
+	b = append(b, f.payload...)
+	return binary.LittleEndian.AppendUint16(b, fcs(b))
+}
+
+// fcs is the 802.15.4 frame check sequence, CRC-16/KERMIT.
+func fcs(data []byte) uint16 {
+	crc := uint16(0)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
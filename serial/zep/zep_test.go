@@ -0,0 +1,107 @@
+package zep
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/daedaluz/goconbee/serial"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFCS(t *testing.T) {
+	if crc := fcs([]byte("123456789")); crc != 0x2189 {
+		t.Fatalf("expected 0x2189, got 0x%.4x", crc)
+	}
+}
+
+func TestPcapng(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewPcapngWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewExporter(w, 11, 0x1a62)
+	ind := &serial.ApsData{
+		DstAddress: serial.Address{Mode: serial.AddressNWK, Short: 0x0000, Endpoint: 1},
+		SrcAddress: serial.Address{Mode: serial.AddressNWK, Short: 0x4321, Endpoint: 2},
+		ProfileID:  0x0104,
+		ClusterID:  0x0006,
+		Data:       []byte{0x18, 0x01, 0x0a},
+		LQI:        200,
+	}
+	if err := e.Indication(time.Now(), ind); err != nil {
+		t.Fatal(err)
+	}
+
+	var blocks [][]byte
+	data := buf.Bytes()
+	for len(data) > 0 {
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || binary.LittleEndian.Uint32(data[length-4:]) != length {
+			t.Fatal("bad block length", length)
+		}
+		blocks = append(blocks, data[:length])
+		data = data[length:]
+	}
+	if len(blocks) != 3 || binary.LittleEndian.Uint32(blocks[2]) != blockEnhancedPacket {
+		t.Fatal("expected section header, interface description and packet, got", len(blocks), "blocks")
+	}
+
+	ip := blocks[2][28:]
+	if ipChecksum(ip[:20]) != 0 || binary.BigEndian.Uint16(ip[22:]) != Port {
+		t.Fatal("bad ip/udp header", ip[:28])
+	}
+	packet := ip[28:]
+	if string(packet[:2]) != "EX" || packet[2] != 2 || packet[4] != 11 || packet[8] != 200 {
+		t.Fatal("bad zep header", packet[:32])
+	}
+	mac := packet[32 : 32+int(packet[31])]
+	if fcs(mac[:len(mac)-2]) != binary.LittleEndian.Uint16(mac[len(mac)-2:]) {
+		t.Fatal("bad fcs")
+	}
+	if binary.LittleEndian.Uint16(mac[3:]) != 0x1a62 || binary.LittleEndian.Uint16(mac[7:]) != 0x4321 {
+		t.Fatal("bad mac header", mac[:9])
+	}
+	aps := mac[17:]
+	if aps[1] != 1 || binary.LittleEndian.Uint16(aps[2:]) != 0x0006 || binary.LittleEndian.Uint16(aps[4:]) != 0x0104 || aps[6] != 2 {
+		t.Fatal("bad aps header", aps[:8])
+	}
+	if !bytes.Equal(aps[8:len(aps)-2], ind.Data) {
+		t.Fatal("bad payload", aps[8:len(aps)-2])
+	}
+}
+
+func TestUDP(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	w, err := DialUDP(l.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewExporter(w, 11, 0x1a62)
+	defer e.Close()
+	req := &serial.SendDataRequest{
+		DstAddress: serial.Address{Mode: serial.AddressGroup, Short: 0x0001},
+		ProfileID:  0x0104,
+		ClusterID:  0x0006,
+		SrcEP:      1,
+		Data:       []byte{0x01, 0x01, 0x02},
+	}
+	if err := e.Request(time.Now(), req); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 256)
+	l.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := l.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aps := buf[32+17 : n]
+	if aps[0] != 0x0c || binary.LittleEndian.Uint16(aps[1:]) != 0x0001 {
+		t.Fatal("expected group delivery, got", aps[:3])
+	}
+}